@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// A bundle holds several root commands, as a JSON array of specs ([{"command": {...}}, ...])
+// or as a stream of specs (one per line, NDJSON). A single spec is a bundle of one.
+
+// parseJsonBundle converts the specs of a JSON bundle into data model objects
+func parseJsonBundle(data []byte) ([]*BceCommand, error) {
+	var specs []interface{}
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		err := json.Unmarshal(data, &specs)
+		if err != nil {
+			return nil, jsonErrorLocation(data, err)
+		}
+	} else {
+		decoder := json.NewDecoder(bytes.NewReader(data))
+		for {
+			var spec interface{}
+			err := decoder.Decode(&spec)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, jsonErrorLocation(data, err)
+			}
+			specs = append(specs, spec)
+		}
+	}
+	return decodeBundle(specs)
+}
+
+// decodeBundle converts the decoded specs of a bundle into data model objects. Every spec is checked,
+// and all the problems are reported together (by position and name), so nothing is imported from a broken bundle.
+func decodeBundle(specs []interface{}) ([]*BceCommand, error) {
+	if len(specs) == 0 {
+		return nil, errors.New("no command in the bundle")
+	}
+
+	if len(specs) == 1 {
+		cmd, err := decodeSpec(specs[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*BceCommand{cmd}, nil
+	}
+
+	var cmds []*BceCommand
+	var problems []string
+	// the uuids are unique across the bundle, as its commands share the database
+	uuids := map[string]string{}
+	for i, spec := range specs {
+		bundlePath := fmt.Sprintf("command %d%s", i+1, specName(spec))
+		d := &bceSpecDecoder{uuids: uuids, bundlePath: bundlePath + ": "}
+		cmd, err := d.decode(spec)
+		if err != nil {
+			for _, problem := range strings.Split(err.Error(), "\n") {
+				problems = append(problems, bundlePath+": "+problem)
+			}
+			continue
+		}
+		for _, other := range cmds {
+			if other.Name == cmd.Name {
+				problems = append(problems, fmt.Sprintf("command %d (%s): duplicates an earlier command", i+1, cmd.Name))
+			}
+		}
+		cmds = append(cmds, cmd)
+	}
+	if len(problems) > 0 {
+		return nil, errors.New(strings.Join(problems, "\n"))
+	}
+	return cmds, nil
+}
+
+// specName is the name of a spec's command, for reporting a problem with the spec
+func specName(spec interface{}) string {
+	wrapper, _ := spec.(map[string]interface{})
+	cmd, _ := wrapper["command"].(map[string]interface{})
+	name, _ := cmd["name"].(string)
+	if len(name) == 0 {
+		return ""
+	}
+	return " (" + name + ")"
+}
+
+// DBImportCommands replaces (or merges into) the commands in the database, in one transaction, recording a revision
+// of each. A merge, or a dry run, first prints the changes to each command.
+func DBImportCommands(conn *sql.DB, cmds []*BceCommand, options BceImportOptions) error {
+	dbConn, tx, err := DBBegin(conn)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	for i, cmd := range cmds {
+		err = dbImportCommand(tx, cmd, options)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("command %d (%s): %w", i+1, cmd.Name, err)
+		}
+	}
+
+	if options.DryRun {
+		return tx.Rollback()
+	}
+	return tx.Commit()
+}
+
+func dbImportCommand(conn BceDBConn, cmd *BceCommand, options BceImportOptions) error {
+	existing, err := DBQueryCommand(conn, cmd.Name)
+	if err != nil {
+		return err
+	}
+	if (existing != nil) && (existing.Name != cmd.Name) {
+		// an alias of another command, which the import doesn't replace
+		existing = nil
+	}
+	action := RevisionImport
+	if options.Merge && (existing != nil) {
+		merged := mergeCommand(existing, cmd, options.Prune)
+		cmd = &merged
+		action = RevisionMerge
+	}
+
+	if options.Merge || options.DryRun {
+		changes := diffCommand(existing, cmd)
+		if len(changes) == 0 {
+			fmt.Printf("= command %s: no changes\n", cmd.Name)
+		}
+		printChanges(os.Stdout, changes)
+	}
+	if options.DryRun {
+		return nil
+	}
+
+	err = DBInsertRevision(conn, cmd.Name, action, options.Source, options.Checksum, existing)
+	if err != nil {
+		return err
+	}
+	return cmd.ReplaceDB(conn)
+}
+
+// queryExportCommands loads the command to export, or every root command (of the layer, or visible through the layers)
+// when the command name is ExportAllCommands
+func queryExportCommands(layer string, commandName string) ([]*BceCommand, error) {
+	if commandName != ExportAllCommands {
+		cmd, err := DBQueryCommandFromLayer(layer, commandName)
+		if err != nil {
+			return nil, err
+		}
+		return []*BceCommand{cmd}, nil
+	}
+
+	layers, err := DBOpenLayerOrLayers(layer)
+	if err != nil {
+		return nil, err
+	}
+	defer DBCloseLayers(layers)
+
+	names, owners, err := DBQueryRootCommandsLayered(layers)
+	if err != nil {
+		return nil, err
+	}
+	var cmds []*BceCommand
+	for _, name := range names {
+		cmd, err := DBQueryCommand(owners[name].Conn, name)
+		if err != nil {
+			return nil, err
+		}
+		if cmd != nil {
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds, nil
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// a uuid is unique across the bundle, not only within each of its specs
+func TestParseJsonBundleDuplicateUuids(t *testing.T) {
+	_, err := parseJsonBundle([]byte(`[
+		{"command": {"uuid": "00000000-0000-0000-0000-0000000b0001", "name": "alpha"}},
+		{"command": {"name": "beta", "sub_commands": [
+			{"uuid": "00000000-0000-0000-0000-0000000b0001", "name": "get"}
+		]}}
+	]`))
+	if err == nil {
+		t.Fatal("a bundle reusing a uuid was accepted")
+	}
+	expected := "command 2 (beta): command.sub_commands[0].uuid: duplicates the uuid of command 1 (alpha): command"
+	if !strings.Contains(err.Error(), expected) {
+		t.Errorf("expected %q, got %q", expected, err)
+	}
+
+	cmds, err := parseJsonBundle([]byte(`
+		{"command": {"uuid": "00000000-0000-0000-0000-0000000b0001", "name": "alpha"}}
+		{"command": {"uuid": "00000000-0000-0000-0000-0000000b0002", "name": "beta"}}
+	`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cmds) != 2 {
+		t.Errorf("expected 2 commands, got %d", len(cmds))
+	}
+}
+
+// a bundle is imported whole or not at all: when its third command fails, the first two aren't written either
+func TestDBImportCommandsRollsBack(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+	t.Setenv(DBSystemPathEnvVar, filepath.Join(dir, "system.db"))
+
+	conn, err := DBOpenLayer(DBLayerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DBClose(conn)
+	// each statement run on the database gets a new connection, so a transaction begun on one of them is lost
+	conn.SetMaxIdleConns(0)
+
+	argUuid := "00000000-0000-0000-0000-0000000b1011"
+	cmds := []*BceCommand{
+		{Uuid: "00000000-0000-0000-0000-0000000b1001", Name: "alpha", Args: []BceCommandArg{
+			{Uuid: argUuid, CmdUuid: "00000000-0000-0000-0000-0000000b1001", ArgType: ArgTypeNone, LongName: "--all"},
+		}},
+		{Uuid: "00000000-0000-0000-0000-0000000b1002", Name: "beta"},
+		// the arg's uuid is already taken, by alpha's
+		{Uuid: "00000000-0000-0000-0000-0000000b1003", Name: "gamma", Args: []BceCommandArg{
+			{Uuid: argUuid, CmdUuid: "00000000-0000-0000-0000-0000000b1003", ArgType: ArgTypeNone, LongName: "--all"},
+		}},
+	}
+	err = DBImportCommands(conn, cmds, BceImportOptions{Source: "bundle.json"})
+	if err == nil {
+		t.Fatal("the bundle was imported")
+	}
+	if !strings.HasPrefix(err.Error(), "command 3 (gamma): ") {
+		t.Errorf("expected the third command's failure, got %q", err)
+	}
+
+	for _, name := range []string{"alpha", "beta"} {
+		cmd, err := DBQueryCommand(conn, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd != nil {
+			t.Errorf("%s was written", name)
+		}
+		revisions, err := DBQueryRevisions(conn, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(revisions) > 0 {
+			t.Errorf("%s has revisions %v", name, revisions)
+		}
+	}
+}
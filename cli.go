@@ -1,30 +1,51 @@
 package main
 
 import (
-	"encoding/json"
 	"errors"
 	"flag"
-	"github.com/google/uuid"
 	"io"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 )
 
 type BceCommandJsonWrapper struct {
-	Command BceCommand `json:"command"`
+	Command BceCommand `json:"command" yaml:"command" toml:"command"`
 }
 
 func processCli() error {
 	var err error
 
 	fHelp := flag.Bool("help", false, "get help")
-	fExport := flag.String("export", "", "export command")
+	fExport := flag.String("export", "", "export command (all for every command)")
 	fImport := flag.Bool("import", false, "import")
-	fFormat := flag.String("format", "sqlite", "file format")
+	fFormat := flag.String("format", "sqlite", "file format (sqlite, json, yaml, toml, index, bash, zsh, fish, powershell)")
 	fFilename := flag.String("filename", "", "file Name")
 	fUrl := flag.String("url", "", "URL")
+	fDb := flag.String("db", "", "user database file (default: $BCE_DB, then $XDG_DATA_HOME/bce/completion.db)")
+	fInit := flag.String("init", "", "print the shell registration (bash, zsh, fish)")
+	fZsh := flag.Bool("zsh", false, "print zsh completion candidates")
+	fFish := flag.Bool("fish", false, "print fish completion candidates")
+	fLine := flag.String("line", "", "command line to complete")
+	fPoint := flag.Int("point", -1, "cursor position in the command line")
+	fLayer := flag.String("layer", "", "database layer (user, system) to import into, export from or hide in")
+	fHide := flag.String("hide", "", "hide a command of a lower layer")
+	fUnhide := flag.String("unhide", "", "stop hiding a command of a lower layer")
+	fCache := flag.String("cache", "", "manage the generator cache, in $BCE_CACHE_DB or $XDG_CACHE_HOME/bce (clear, stats)")
+	fDaemon := flag.Bool("daemon", false, "serve completions from memory, on a Unix socket ($BCE_SOCKET)")
+	fValidate := flag.Bool("validate", false, "check spec files (--filename, and any further arguments) without importing them")
+	fExportSchema := flag.Bool("export-schema", false, "write the JSON Schema of the spec format to --filename (or stdout)")
+	fSeed := flag.String("seed", "", "list the embedded specs (list), or re-seed them into a layer (all, or command names)")
+	fMerge := flag.Bool("merge", false, "import by merging into the existing commands (matching by uuid, then name), rather than replacing them")
+	fPrune := flag.Bool("prune", false, "when merging, remove what the imported commands don't have")
+	fDryRun := flag.Bool("dry-run", false, "print the changes an import would make, without making them")
+	fDiff := flag.String("diff", "", "compare a command (all for every command) of --filename with the database")
+	fDiffOutput := flag.String("diff-output", "text", "diff output (text, json)")
+	fHistory := flag.String("history", "", "list the revisions (imports, merges, seeds and rollbacks) of a command in a layer")
+	fRollback := flag.String("rollback", "", "restore a command of a layer to how it was before a revision (--revision)")
+	fRevision := flag.Int64("revision", 0, "the revision to roll back, as listed by --history")
 	flag.Parse()
 
 	if *fHelp {
@@ -32,293 +53,245 @@ func processCli() error {
 		return nil
 	}
 
+	DBPathFlag = *fDb
+
+	if len(*fInit) > 0 {
+		return processInit(*fInit, os.Stdout)
+	}
+
+	if *fZsh {
+		return processZshCompletion(*fLine, *fPoint, os.Stdout)
+	}
+
+	if *fFish {
+		return processFishCompletion(*fLine, os.Stdout)
+	}
+
+	if *fDaemon {
+		return processDaemon()
+	}
+
+	if len(*fCache) > 0 {
+		return processCache(*fCache, flag.Args())
+	}
+
+	// writes go to the user layer, unless another layer is chosen
+	var destLayer = *fLayer
+	if len(destLayer) == 0 {
+		destLayer = DBLayerUser
+	}
+
+	if *fExportSchema {
+		return processExportSchema(*fFilename)
+	}
+
+	if *fValidate {
+		var filenames []string
+		if len(*fFilename) > 0 {
+			filenames = append(filenames, *fFilename)
+		}
+		return processValidate(append(filenames, flag.Args()...), *fFormat)
+	}
+
+	if len(*fSeed) > 0 {
+		return processSeed(*fSeed, flag.Args(), destLayer)
+	}
+
+	if len(*fHistory) > 0 {
+		return processHistory(*fHistory, destLayer)
+	}
+
+	if len(*fRollback) > 0 {
+		err = processRollback(*fRollback, *fRevision, flag.Args(), destLayer)
+		if err != nil {
+			return err
+		}
+		return DBIndexRefresh(destLayer)
+	}
+
+	if len(*fHide) > 0 {
+		err = processHideCommand(destLayer, *fHide, true)
+		if err != nil {
+			return err
+		}
+		return DBIndexRefresh(destLayer)
+	}
+	if len(*fUnhide) > 0 {
+		err = processHideCommand(destLayer, *fUnhide, false)
+		if err != nil {
+			return err
+		}
+		return DBIndexRefresh(destLayer)
+	}
+
+	if len(*fDiff) > 0 {
+		if len(*fFilename) == 0 {
+			return errors.New("diff requires a value for file")
+		}
+		return processDiff(*fDiff, *fFilename, *fFormat, *fLayer, *fDiffOutput, os.Stdout)
+	}
+
+	if (len(*fExport) > 0) && (*fFormat == FormatIndex) {
+		// the index goes next to the database, unless a file is given
+		return processExportIndex(*fExport, *fFilename, *fLayer)
+	}
+
 	if len(*fExport) > 0 {
 		// ensure we have a filename and a format
 		if (len(*fFormat) == 0) || (len(*fFilename) == 0) {
 			return errors.New("export requires values for format and file")
 		}
-		if *fFormat == "json" {
-			err = processExportJson(*fExport, *fFilename)
+		if isSpecFormat(*fFormat) {
+			err = processExportSpec(*fExport, *fFormat, *fFilename, *fLayer)
+		} else if isScriptFormat(*fFormat) {
+			err = processExportScript(*fExport, *fFormat, *fFilename, *fLayer)
+		} else if *fFormat == FormatSqlite {
+			err = processExportSqlite(*fExport, *fFilename, *fLayer)
 		} else {
-			err = processExportSqlite(*fExport, *fFilename)
+			return errors.New("unknown export format: " + *fFormat + " (sqlite, json, yaml, toml, index, " + strings.Join(scriptFormats, ", ") + ")")
 		}
 	} else if *fImport {
 		// ensure we have a filename or url
 		if (len(*fFilename) == 0) && (len(*fUrl) == 0) {
 			return errors.New("import requires values for either file or url")
 		}
+		if !isSpecFormat(*fFormat) && (*fFormat != FormatSqlite) {
+			return errors.New("unknown import format: " + *fFormat + " (sqlite, json, yaml, toml)")
+		}
+		if *fPrune && !*fMerge {
+			return errors.New("prune requires merge")
+		}
+		importOptions := BceImportOptions{Merge: *fMerge, Prune: *fPrune, DryRun: *fDryRun}
 		if len(*fFilename) > 0 {
-			if *fFormat == "json" {
-				err = processImportJsonFile(*fFilename)
+			if isSpecFormat(*fFormat) {
+				err = processImportSpecFile(*fFilename, *fFormat, destLayer, importOptions)
 			} else {
-				err = processImportSqlite(*fFilename)
+				err = processImportSqlite(*fFilename, destLayer, importOptions)
 			}
 		} else {
-			if *fFormat == "json" {
-				err = processImportJsonUrl(*fUrl)
+			if isSpecFormat(*fFormat) {
+				err = processImportSpecUrl(*fUrl, *fFormat, destLayer, importOptions)
 			} else {
-				return errors.New("import from url must be json format")
+				return errors.New("import from url must be json, yaml or toml format")
 			}
 		}
+		if (err == nil) && !*fDryRun {
+			// keep the layer's index (if it has one) in step with its database
+			err = DBIndexRefresh(destLayer)
+		}
 	}
 
 	return err
 }
 
-func processImportSqlite(filename string) error {
-	// open the source database
-	srcConn, err := DBOpen(filename)
+func processImportSqlite(filename string, layer string, options BceImportOptions) error {
+	cmds, err := querySqliteCommands(filename)
 	if err != nil {
 		return err
 	}
-	defer DBClose(srcConn)
 
-	// explicitly start a transaction, since this will be done automatically (per statement) otherwise
-	_, err = srcConn.Exec("BEGIN TRANSACTION;")
+	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
+	options.Source, options.Checksum = sourcePath(filename), checksum(data)
 
 	// open the dest database
-	destConn, err := DBOpen(DBFilename)
+	destConn, err := DBOpenLayer(layer)
 	if err != nil {
 		return err
 	}
 	defer DBClose(destConn)
 
-	// explicitly start a transaction
-	_, err = destConn.Exec("BEGIN TRANSACTION;")
+	// push the commands to dest
+	return DBImportCommands(destConn, cmds, options)
+}
+
+// querySqliteCommands loads every root command of a database file
+func querySqliteCommands(filename string) ([]*BceCommand, error) {
+	// open the source database
+	srcConn, err := DBOpen(filename)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	defer DBClose(srcConn)
+
+	// explicitly start a transaction, since this will be done automatically (per statement) otherwise
+	_, err = srcConn.Exec("BEGIN TRANSACTION;")
+	if err != nil {
+		return nil, err
 	}
 
 	// get a list of the top-level commands in source database
 	cmdNames, err := DBQueryRootCommandNames(srcConn)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	// load each command from src and push to dest
+	// load each command from src
+	var cmds []*BceCommand
 	for _, cmdName := range cmdNames {
 		cmd, err := DBQueryCommand(srcConn, cmdName)
 		if err != nil {
-			return err
+			return nil, err
 		}
 		if cmd != nil {
-			// delete the command from dest (cascade)
-			err = DBDeleteCommand(destConn, cmd.Name)
-			if err != nil {
-				return err
-			}
-			// insert the command into dest
-			err = cmd.InsertDB(destConn)
-			if err != nil {
-				return err
-			}
+			cmds = append(cmds, cmd)
 		}
 	}
-
-	// commit the transactions
-	_, err = destConn.Exec("COMMIT;")
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return cmds, nil
 }
 
-func processImportJsonFile(filename string) error {
+// processImportSpecFile imports a spec, or a bundle of specs, replacing (or merging into) the commands of the same name
+func processImportSpecFile(filename string, format string, layer string, options BceImportOptions) error {
 	// read in the file
 	data, err := ioutil.ReadFile(filename)
 	if err != nil {
 		return err
 	}
 
-	// load the JSON into a map
-	var mapData map[string]interface{}
-	json.Unmarshal(data, &mapData)
-
-	// convert the map into data model objects
-	cmdData := mapData["command"].(map[string]interface{})
-	cmd, err := createBceCommandFromJson(nil, cmdData)
-	if err != nil {
-		return err
-	}
-
-	// open the dest database
-	destConn, err := DBOpen(DBFilename)
-	if err != nil {
-		return err
-	}
-	defer DBClose(destConn)
-
-	// explicitly start a transaction
-	_, err = destConn.Exec("BEGIN TRANSACTION;")
+	cmds, err := parseSpecBundle(data, format)
 	if err != nil {
 		return err
 	}
 
-	// delete the command (cascading) if it exists
-	err = DBDeleteCommand(destConn, cmd.Name)
-	if err != nil {
-		return err
-	}
-
-	// insert the command data
-	err = cmd.InsertDB(destConn)
-	if err != nil {
-		return err
+	// a download is recorded by its URL
+	if len(options.Source) == 0 {
+		options.Source = sourcePath(filename)
 	}
+	options.Checksum = checksum(data)
 
-	// commit the transaction
-	_, err = destConn.Exec("COMMIT;")
+	// open the dest database
+	destConn, err := DBOpenLayer(layer)
 	if err != nil {
 		return err
 	}
+	defer DBClose(destConn)
 
-	return nil
+	return DBImportCommands(destConn, cmds, options)
 }
 
-func processImportJsonUrl(url string) error {
-	jsonFile, err := os.CreateTemp("", "")
+func processImportSpecUrl(url string, format string, layer string, options BceImportOptions) error {
+	specFile, err := os.CreateTemp("", "")
 	if err != nil {
 		return err
 	}
-	filename := jsonFile.Name()
+	filename := specFile.Name()
 	// defers are unwound in LIFO
 	defer os.Remove(filename)
-	defer jsonFile.Close()
+	defer specFile.Close()
 
-	err = downloadFile(url, jsonFile)
+	err = downloadFile(url, specFile)
 	if err != nil {
 		return err
 	}
-	return processImportJsonFile(filename)
-}
-
-func createBceCommandFromJson(parentUuid *string, data map[string]interface{}) (*BceCommand, error) {
-	cmdUuid, ok := data["uuid"].(string)
-	if !ok {
-		cmdUuid = uuid.New().String()
-	}
-	name, ok := data["name"].(string)
-	if !ok {
-		return nil, errors.New("command.name is a required JSON attribute")
-	}
-
-	// collect the aliases
-	var aliases []BceCommandAlias
-	jAliases, ok := data["aliases"].([]interface{})
-	for _, ijAlias := range jAliases {
-		jAlias := ijAlias.(map[string]interface{})
-		alias, err := createBceCommandAliasFromJson(cmdUuid, jAlias)
-		if err != nil {
-			return nil, err
-		}
-		aliases = append(aliases, *alias)
-	}
-
-	// collect the args
-	var args []BceCommandArg
-	jArgs, ok := data["args"].([]interface{})
-	for _, ijArg := range jArgs {
-		jArg := ijArg.(map[string]interface{})
-		arg, err := createBceCommandArgFromJson(cmdUuid, jArg)
-		if err != nil {
-			return nil, err
-		}
-		args = append(args, *arg)
-	}
-
-	var subCmds []BceCommand
-	jSubCmds, ok := data["sub_commands"].([]interface{})
-	for _, ijSubCmd := range jSubCmds {
-		jSubCmd := ijSubCmd.(map[string]interface{})
-		subCmd, err := createBceCommandFromJson(&cmdUuid, jSubCmd)
-		if err != nil {
-			return nil, err
-		}
-		subCmds = append(subCmds, *subCmd)
-	}
-
-	cmd := BceCommand{Uuid: cmdUuid, Name: name, ParentCmdUuid: parentUuid, Args: args, SubCommands: subCmds}
-	return &cmd, nil
-}
-
-func createBceCommandArgFromJson(cmdUuid string, data map[string]interface{}) (*BceCommandArg, error) {
-	argUuid, ok := data["uuid"].(string)
-	if !ok {
-		argUuid = uuid.New().String()
-	}
-	argType, ok := data["arg_type"].(string)
-	if !ok {
-		return nil, errors.New("arg.arg_type is a required attribute")
-	}
-	description, ok := data["description"].(string)
-	if !ok {
-		return nil, errors.New("arg.description is a required attribute")
-	}
-	longName, ok := data["long_name"].(string)
-	shortName, ok := data["short_name"].(string)
-
-	// collect the opts
-	var opts []BceCommandOpt
-	jOpts, ok := data["opts"].([]interface{})
-	for _, ijOpt := range jOpts {
-		jOpt := ijOpt.(map[string]interface{})
-		opt, err := createBceCommandOptFromJson(argUuid, jOpt)
-		if err != nil {
-			return nil, err
-		}
-		opts = append(opts, *opt)
-	}
-
-	arg := BceCommandArg{Uuid: argUuid, CmdUuid: cmdUuid, ArgType: argType, Description: description, LongName: longName, ShortName: shortName, Opts: opts}
-	return &arg, nil
+	options.Source = url
+	return processImportSpecFile(filename, format, layer, options)
 }
 
-func createBceCommandOptFromJson(argUuid string, data map[string]interface{}) (*BceCommandOpt, error) {
-	optUuid, ok := data["uuid"].(string)
-	if !ok {
-		optUuid = uuid.New().String()
-	}
-	name, ok := data["name"].(string)
-	if !ok {
-		return nil, errors.New("opt.name is a required attribute")
-	}
-	opt := BceCommandOpt{Uuid: optUuid, ArgUuid: argUuid, Name: name}
-	return &opt, nil
-}
-
-func createBceCommandAliasFromJson(cmdUuid string, data map[string]interface{}) (*BceCommandAlias, error) {
-	aliasUuid, ok := data["uuid"].(string)
-	if !ok {
-		aliasUuid = uuid.New().String()
-	}
-	name, ok := data["name"].(string)
-	if !ok {
-		return nil, errors.New("alias.name is a required JSON attribute")
-	}
-	alias := BceCommandAlias{Uuid: aliasUuid, Name: name, CmdUuid: cmdUuid}
-	return &alias, nil
-}
-
-func processExportSqlite(commandName string, filename string) error {
-	// open the source database
-	srcConn, err := DBOpen(DBFilename)
-	if err != nil {
-		return err
-	}
-	defer DBClose(srcConn)
-
-	// explicitly start a transaction, since this will be done automatically (per statement) otherwise
-	_, err = srcConn.Exec("BEGIN TRANSACTION;")
-	if err != nil {
-		return err
-	}
-
-	// load the command hierarchy
-	cmd, err := DBQueryCommand(srcConn, commandName)
+func processExportSqlite(commandName string, filename string, layer string) error {
+	// load the command hierarchies
+	cmds, err := queryExportCommands(layer, commandName)
 	if err != nil {
 		return err
 	}
@@ -346,10 +319,12 @@ func processExportSqlite(commandName string, filename string) error {
 		return err
 	}
 
-	// insert the BceCommand (recursively to children)
-	err = cmd.InsertDB(destConn)
-	if err != nil {
-		return err
+	// insert the BceCommands (recursively to children)
+	for _, cmd := range cmds {
+		err = cmd.InsertDB(destConn)
+		if err != nil {
+			return err
+		}
 	}
 
 	// commit the transaction
@@ -358,28 +333,18 @@ func processExportSqlite(commandName string, filename string) error {
 	return err
 }
 
-func processExportJson(commandName string, filename string) error {
-	// open the source database
-	srcConn, err := DBOpen(DBFilename)
-	if err != nil {
-		return err
-	}
-
-	// explicitly start a transaction, since this will be done automatically (per statement) otherwise
-	_, err = srcConn.Exec("BEGIN TRANSACTION;")
+// processExportSpec exports a command as a spec, or every root command (all) as a bundle
+func processExportSpec(commandName string, format string, filename string, layer string) error {
+	// load the command hierarchies
+	cmds, err := queryExportCommands(layer, commandName)
 	if err != nil {
 		return err
 	}
-
-	// load the command hierarchy
-	cmd, err := DBQueryCommand(srcConn, commandName)
-	if err != nil {
-		return err
+	for _, cmd := range cmds {
+		log.Println("exporting", cmd.Name)
 	}
-	log.Println(cmd)
 
-	wrapper := BceCommandJsonWrapper{*cmd}
-	data, err := json.MarshalIndent(wrapper, "", "  ")
+	data, err := marshalSpecs(cmds, format, commandName == ExportAllCommands)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DaemonSocketEnvVar overrides the location of the daemon's socket
+const DaemonSocketEnvVar = "BCE_SOCKET"
+
+// DaemonPollInterval is how often the daemon checks the databases for changes
+const DaemonPollInterval = time.Second
+
+// DaemonDialTimeout bounds how long completion waits to reach the daemon, before completing in-process
+const DaemonDialTimeout = 50 * time.Millisecond
+
+type daemonRequest struct {
+	Line       string   `json:"line"`
+	Point      int      `json:"point"`
+	Dir        string   `json:"dir"`
+	IgnoreCase bool     `json:"ignore_case"`
+	Databases  []string `json:"databases"`
+}
+
+type daemonResponse struct {
+	Recommendations []BceRecommendation `json:"recommendations"`
+	Error           string              `json:"error"`
+}
+
+// bceDaemon holds the databases open, and the commands decoded from them, until the databases change
+type bceDaemon struct {
+	mutex     sync.Mutex
+	databases []string
+	signature string
+	layers    []BceDBLayer
+	layersErr error
+	// commands are the decoded root commands, by the name used on the command line (nil when unknown)
+	commands map[string]*BceCommand
+}
+
+// DaemonSocketPath locates the daemon's socket: $BCE_SOCKET, then $XDG_RUNTIME_DIR, then the temporary directory
+// (which is shared, so the socket's owner is checked before it is used, see daemonCheckSocket)
+func DaemonSocketPath() string {
+	if path := os.Getenv(DaemonSocketEnvVar); len(path) > 0 {
+		return path
+	}
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "bce.sock")
+	}
+	return filepath.Join(os.TempDir(), "bce-"+strconv.Itoa(os.Getuid())+".sock")
+}
+
+// daemonCheckSocket refuses a socket which isn't the current user's. Another user could otherwise create it first
+// in a shared directory, and listen in the daemon's place for the command lines being completed.
+func daemonCheckSocket(path string) error {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if (info.Mode()&os.ModeSocket == 0) || !ok || (int(stat.Uid) != os.Getuid()) {
+		return errors.New(path + " isn't a socket of the current user")
+	}
+	return nil
+}
+
+// daemonDatabases lists the database of each layer, so the client and daemon can check they agree
+func daemonDatabases() ([]string, error) {
+	var databases []string
+	for _, layer := range DBLayers {
+		path, err := DBLayerPath(layer)
+		if err != nil {
+			return nil, err
+		}
+		databases = append(databases, path)
+	}
+	return databases, nil
+}
+
+// daemonCollectCompletions asks the daemon for the recommendations, failing fast when no daemon is listening
+func daemonCollectCompletions(input *BashInput) ([]BceRecommendation, error) {
+	databases, err := daemonDatabases()
+	if err != nil {
+		return nil, err
+	}
+
+	path := DaemonSocketPath()
+	err = daemonCheckSocket(path)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.DialTimeout("unix", path, DaemonDialTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	// the daemon may run a generator
+	err = conn.SetDeadline(time.Now().Add(generatorTimeout() + time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	request := daemonRequest{Line: input.CmdLine, Point: input.CursorPosition, Dir: input.Dir, IgnoreCase: input.IgnoreCase,
+		Databases: databases}
+	err = json.NewEncoder(conn).Encode(&request)
+	if err != nil {
+		return nil, err
+	}
+
+	var response daemonResponse
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if len(response.Error) > 0 {
+		return nil, errors.New(response.Error)
+	}
+	return response.Recommendations, nil
+}
+
+// processDaemon serves completion requests on the socket until interrupted.
+// Generators are run by the daemon, so they see its environment (rather than the shell's).
+func processDaemon() error {
+	if !isDebugEnabled() {
+		log.SetOutput(io.Discard)
+	}
+
+	databases, err := daemonDatabases()
+	if err != nil {
+		return err
+	}
+
+	path := DaemonSocketPath()
+	if _, err := os.Lstat(path); err == nil {
+		err = daemonCheckSocket(path)
+		if err != nil {
+			return err
+		}
+	}
+	if conn, err := net.DialTimeout("unix", path, DaemonDialTimeout); err == nil {
+		conn.Close()
+		return errors.New("a daemon is already listening on " + path)
+	}
+	// remove the socket left behind by a daemon which didn't exit cleanly
+	_ = os.Remove(path)
+
+	// the socket is created private, rather than made so once others may have connected
+	umask := syscall.Umask(0077)
+	listener, err := net.Listen("unix", path)
+	syscall.Umask(umask)
+	if err != nil {
+		return err
+	}
+	defer listener.Close()
+
+	daemon := &bceDaemon{databases: databases}
+	daemon.reload()
+	defer daemon.close()
+	defer GeneratorCacheClose()
+
+	// stop accepting on interrupt, so the socket is removed
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	go daemon.watch()
+
+	fmt.Fprintln(os.Stderr, "Listening on", path)
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		go daemon.serve(conn)
+	}
+}
+
+// databaseSignature summarises the modification time and size of the databases (and their write-ahead logs)
+func (daemon *bceDaemon) databaseSignature() string {
+	var signature string
+	for _, path := range daemon.databases {
+		for _, file := range []string{path, path + "-wal"} {
+			info, err := os.Stat(file)
+			if err == nil {
+				signature += fmt.Sprint(file, info.ModTime().UnixNano(), info.Size())
+			}
+		}
+	}
+	return signature
+}
+
+// reload re-opens the databases, dropping the decoded commands
+func (daemon *bceDaemon) reload() {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+
+	DBCloseLayers(daemon.layers)
+	daemon.signature = daemon.databaseSignature()
+	daemon.layers, daemon.layersErr = DBOpenLayers()
+	daemon.commands = map[string]*BceCommand{}
+	log.Println("daemon loaded databases:", daemon.layersErr)
+}
+
+func (daemon *bceDaemon) close() {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+
+	DBCloseLayers(daemon.layers)
+	daemon.layers = nil
+}
+
+// watch polls the databases, reloading them when they change
+func (daemon *bceDaemon) watch() {
+	for {
+		time.Sleep(DaemonPollInterval)
+		daemon.mutex.Lock()
+		changed := daemon.signature != daemon.databaseSignature()
+		daemon.mutex.Unlock()
+		if changed {
+			daemon.reload()
+		}
+	}
+}
+
+func (daemon *bceDaemon) serve(conn net.Conn) {
+	defer conn.Close()
+
+	var response daemonResponse
+	recommendations, err := daemon.complete(conn)
+	if err != nil {
+		log.Println("daemon request failed:", err)
+		response.Error = err.Error()
+	} else {
+		response.Recommendations = recommendations
+	}
+	err = json.NewEncoder(conn).Encode(&response)
+	if err != nil {
+		log.Println("daemon response failed:", err)
+	}
+}
+
+func (daemon *bceDaemon) complete(conn net.Conn) ([]BceRecommendation, error) {
+	err := conn.SetReadDeadline(time.Now().Add(time.Second))
+	if err != nil {
+		return nil, err
+	}
+	var request daemonRequest
+	err = json.NewDecoder(conn).Decode(&request)
+	if err != nil {
+		return nil, err
+	}
+	if fmt.Sprint(request.Databases) != fmt.Sprint(daemon.databases) {
+		return nil, errors.New("the daemon serves other databases: " + fmt.Sprint(daemon.databases))
+	}
+
+	input := NewCompletionInput(request.Line, request.Point)
+	input.IgnoreCase = request.IgnoreCase
+	input.Dir = request.Dir
+	if input.CmdName == nil {
+		return nil, errors.New("no command in input")
+	}
+
+	cmd, err := daemon.command(*input.CmdName)
+	if err != nil {
+		return nil, err
+	}
+	if cmd == nil {
+		log.Println("unknown command:", *input.CmdName)
+		return nil, nil
+	}
+
+	// without the lock, so a slow generator doesn't hold up the other requests
+	return completeCommand(cmd, input), nil
+}
+
+// command returns a copy of the decoded root command (as completion prunes it), or nil if it doesn't exist
+func (daemon *bceDaemon) command(cmdName string) (*BceCommand, error) {
+	daemon.mutex.Lock()
+	defer daemon.mutex.Unlock()
+
+	if daemon.layersErr != nil {
+		return nil, daemon.layersErr
+	}
+	cmd, ok := daemon.commands[cmdName]
+	if !ok {
+		var err error
+		cmd, err = DBQueryCommandLayered(daemon.layers, cmdName)
+		if err != nil {
+			return nil, err
+		}
+		daemon.commands[cmdName] = cmd
+	}
+	if cmd == nil {
+		return nil, nil
+	}
+	completion := cmd.clone()
+	return &completion, nil
+}
+
+// clone copies the command and all of its descendents
+func (cmd *BceCommand) clone() BceCommand {
+	copied := *cmd
+	copied.Aliases = append([]BceCommandAlias(nil), cmd.Aliases...)
+	copied.Args = nil
+	for _, arg := range cmd.Args {
+		arg.Opts = append([]BceCommandOpt(nil), arg.Opts...)
+		copied.Args = append(copied.Args, arg)
+	}
+	copied.Positionals = nil
+	for _, positional := range cmd.Positionals {
+		positional.Opts = append([]BceCommandOpt(nil), positional.Opts...)
+		copied.Positionals = append(copied.Positionals, positional)
+	}
+	copied.SubCommands = nil
+	for i := range cmd.SubCommands {
+		copied.SubCommands = append(copied.SubCommands, cmd.SubCommands[i].clone())
+	}
+	return copied
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestDaemonCheckSocket(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "bce.sock")
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	if err := daemonCheckSocket(path); err != nil {
+		t.Error(err)
+	}
+
+	// a file planted in the socket's place
+	planted := filepath.Join(dir, "planted.sock")
+	err = ioutil.WriteFile(planted, nil, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daemonCheckSocket(planted) == nil {
+		t.Error("a regular file was used as the socket")
+	}
+
+	// another user's socket
+	if os.Getuid() != 0 {
+		t.Skip("changing the socket's owner needs root")
+	}
+	err = os.Lchown(path, 65534, 65534)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if daemonCheckSocket(path) == nil {
+		t.Error("another user's socket was used")
+	}
+}
+
+// daemonTestRequest sends a completion request to the daemon, through an in-memory connection
+func daemonTestRequest(t *testing.T, daemon *bceDaemon, line string) daemonResponse {
+	client, server := net.Pipe()
+	defer client.Close()
+	go daemon.serve(server)
+
+	request := daemonRequest{Line: line, Point: len(line), Databases: daemon.databases}
+	err := json.NewEncoder(client).Encode(&request)
+	if err != nil {
+		t.Error(err)
+	}
+	var response daemonResponse
+	err = json.NewDecoder(client).Decode(&response)
+	if err != nil {
+		t.Error(err)
+	}
+	return response
+}
+
+// a slow generator doesn't hold up the daemon's other requests
+func TestDaemonConcurrentGenerators(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+	t.Setenv(DBSystemPathEnvVar, filepath.Join(dir, "system.db"))
+	t.Setenv(GeneratorTimeoutEnvVar, "5s")
+
+	conn, err := DBOpenLayer(DBLayerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := BceCommand{Uuid: "00000000-0000-0000-0000-00000000d001", Name: "tool", Positionals: []BceCommandPositional{
+		{Uuid: "00000000-0000-0000-0000-00000000d002", CmdUuid: "00000000-0000-0000-0000-00000000d001", Position: 1,
+			Name: "POD", Arity: ArityExactly, ArgType: ArgTypeGenerator, GeneratorTTL: -1,
+			Generator: writeStubGenerator(t, "sleep 0.5\necho web-1\n")},
+	}}
+	err = cmd.InsertDB(conn)
+	DBClose(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	databases, err := daemonDatabases()
+	if err != nil {
+		t.Fatal(err)
+	}
+	daemon := &bceDaemon{databases: databases}
+	daemon.reload()
+	defer daemon.close()
+
+	start := time.Now()
+	var wait sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			response := daemonTestRequest(t, daemon, "tool ")
+			if (len(response.Recommendations) != 1) || (response.Recommendations[0].Name != "web-1") {
+				t.Errorf("expected web-1, got %v (%s)", response.Recommendations, response.Error)
+			}
+		}()
+	}
+	wait.Wait()
+
+	if elapsed := time.Since(start); elapsed > 900*time.Millisecond {
+		t.Errorf("two requests running a 0.5s generator took %v, they were served one at a time", elapsed)
+	}
+}
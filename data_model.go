@@ -1,12 +1,11 @@
 package main
 
-import "database/sql"
-
 const sqlReadCommand = `
-	SELECT c.uuid, c.name, c.parent_cmd
+	SELECT DISTINCT c.uuid, c.name, c.parent_cmd
 	FROM command c
-	JOIN command_alias a ON a.cmd_uuid = c.uuid
-	WHERE c.name = ?1 OR a.name = ?2
+	LEFT JOIN command_alias a ON a.cmd_uuid = c.uuid
+	WHERE c.parent_cmd IS NULL
+	AND (c.name = ?1 OR a.name = ?2)
 `
 
 const sqlReadCommandAliases = `
@@ -15,15 +14,8 @@ const sqlReadCommandAliases = `
 	WHERE a.cmd_uuid = ?1
 `
 
-const sqlReadSubCommands = `
-	SELECT c.uuid, c.name, c.parent_cmd
-	FROM command c
-	WHERE c.parent_cmd = ?1
-	ORDER BY c.name
-`
-
 const sqlReadCommandArgs = `
-	SELECT ca.uuid, ca.cmd_uuid, ca.arg_type, ca.description, ca.long_name, ca.short_name
+	SELECT ca.uuid, ca.cmd_uuid, ca.arg_type, ca.description, COALESCE(ca.long_name, ''), COALESCE(ca.short_name, ''), COALESCE(ca.file_filter, ''), COALESCE(ca.generator, ''), COALESCE(ca.generator_ttl, 0)
 	FROM command_arg ca
 	JOIN command c ON c.uuid = ca.cmd_uuid
 	WHERE c.uuid = ?1
@@ -38,6 +30,20 @@ const sqlReadCommandOpts = `
 	ORDER BY co.name
 `
 
+const sqlReadCommandPositionals = `
+	SELECT cp.uuid, cp.cmd_uuid, cp.position, cp.name, cp.arity, cp.arg_type, cp.description, COALESCE(cp.file_filter, ''), COALESCE(cp.generator, ''), COALESCE(cp.generator_ttl, 0)
+	FROM command_positional cp
+	WHERE cp.cmd_uuid = ?1
+	ORDER BY cp.position
+`
+
+const sqlReadPositionalOpts = `
+	SELECT po.uuid, po.positional_uuid, po.name
+	FROM command_positional_opt po
+	WHERE po.positional_uuid = ?1
+	ORDER BY po.name
+`
+
 const sqlReadRootCommandNames = `
 	SELECT c.name
 	FROM command c
@@ -45,6 +51,20 @@ const sqlReadRootCommandNames = `
 	ORDER BY c.name
 `
 
+const sqlReadRootCommandAliases = `
+	SELECT c.name, a.name
+	FROM command_alias a
+	JOIN command c ON c.uuid = a.cmd_uuid
+	WHERE c.parent_cmd IS NULL
+	ORDER BY c.name, a.name
+`
+
+const sqlReadCommandTombstones = `
+	SELECT t.name
+	FROM command_tombstone t
+	ORDER BY t.name
+`
+
 const sqlWriteCommand = `
 	INSERT INTO command
 		(uuid, name, parent_cmd)
@@ -61,9 +81,9 @@ const sqlWriteCommandAlias = `
 
 const sqlWriteCommandArg = `
     INSERT INTO command_arg
-        (uuid, cmd_uuid, arg_type, description, long_name, short_name)
+        (uuid, cmd_uuid, arg_type, description, long_name, short_name, file_filter, generator, generator_ttl)
     VALUES
-		(?1, ?2, ?3, ?4, ?5, ?6)
+		(?1, ?2, ?3, ?4, NULLIF(?5, ''), NULLIF(?6, ''), ?7, ?8, ?9)
 `
 
 const sqlWriteCommandOpt = `
@@ -73,6 +93,32 @@ const sqlWriteCommandOpt = `
 		(?1, ?2, ?3)
 `
 
+const sqlWriteCommandPositional = `
+	INSERT INTO command_positional
+		(uuid, cmd_uuid, position, name, arity, arg_type, description, file_filter, generator, generator_ttl)
+	VALUES
+		(?1, ?2, ?3, ?4, ?5, ?6, ?7, ?8, ?9, ?10)
+`
+
+const sqlWritePositionalOpt = `
+	INSERT INTO command_positional_opt
+		(uuid, positional_uuid, name)
+	VALUES
+		(?1, ?2, ?3)
+`
+
+const sqlWriteCommandTombstone = `
+	INSERT OR IGNORE INTO command_tombstone
+		(name)
+	VALUES
+		(?1)
+`
+
+const sqlDeleteCommandTombstone = `
+	DELETE FROM command_tombstone
+	WHERE name = ?1
+`
+
 const sqlDeleteCommand = `
 	DELETE FROM command
 	WHERE name = ?1
@@ -80,39 +126,77 @@ const sqlDeleteCommand = `
 `
 
 type BceCommand struct {
-	Uuid               string            `json:"uuid"`
-	Name               string            `json:"name"`
-	ParentCmdUuid      *string           `json:"-"`
-	Aliases            []BceCommandAlias `json:"aliases"`
-	SubCommands        []BceCommand      `json:"sub_commands"`
-	Args               []BceCommandArg   `json:"args"`
-	IsPresentOnCmdLine bool              `json:"-"`
+	Uuid               string                 `json:"uuid" yaml:"uuid" toml:"uuid"`
+	Name               string                 `json:"name" yaml:"name" toml:"name"`
+	ParentCmdUuid      *string                `json:"-" yaml:"-" toml:"-"`
+	Aliases            []BceCommandAlias      `json:"aliases" yaml:"aliases,omitempty" toml:"aliases,omitempty"`
+	SubCommands        []BceCommand           `json:"sub_commands" yaml:"sub_commands,omitempty" toml:"sub_commands,omitempty"`
+	Args               []BceCommandArg        `json:"args" yaml:"args,omitempty" toml:"args,omitempty"`
+	Positionals        []BceCommandPositional `json:"positionals" yaml:"positionals,omitempty" toml:"positionals,omitempty"`
+	IsPresentOnCmdLine bool                   `json:"-" yaml:"-" toml:"-"`
 }
 
 type BceCommandAlias struct {
-	Uuid    string `json:"uuid"`
-	CmdUuid string `json:"-"`
-	Name    string `json:"name"`
+	Uuid    string `json:"uuid" yaml:"uuid" toml:"uuid"`
+	CmdUuid string `json:"-" yaml:"-" toml:"-"`
+	Name    string `json:"name" yaml:"name" toml:"name"`
 }
 
+const (
+	ArgTypeNone      = "NONE"
+	ArgTypeOption    = "OPTION"
+	ArgTypeFile      = "FILE"
+	ArgTypeDirectory = "DIRECTORY"
+	ArgTypeText      = "TEXT"
+	ArgTypeGenerator = "GENERATOR"
+)
+
 type BceCommandArg struct {
-	Uuid               string          `json:"uuid"`
-	CmdUuid            string          `json:"-"`
-	ArgType            string          `json:"arg_type"`
-	Description        string          `json:"description"`
-	LongName           string          `json:"long_name"`
-	ShortName          string          `json:"short_name"`
-	IsPresentOnCmdLine bool            `json:"-"`
-	Opts               []BceCommandOpt `json:"opts"`
+	Uuid               string          `json:"uuid" yaml:"uuid" toml:"uuid"`
+	CmdUuid            string          `json:"-" yaml:"-" toml:"-"`
+	ArgType            string          `json:"arg_type" yaml:"arg_type" toml:"arg_type"`
+	Description        string          `json:"description" yaml:"description" toml:"description"`
+	LongName           string          `json:"long_name" yaml:"long_name,omitempty" toml:"long_name,omitempty"`
+	ShortName          string          `json:"short_name" yaml:"short_name,omitempty" toml:"short_name,omitempty"`
+	FileFilter         string          `json:"file_filter" yaml:"file_filter,omitempty" toml:"file_filter,omitempty"`
+	Generator          string          `json:"generator" yaml:"generator,omitempty" toml:"generator,omitempty"`
+	GeneratorTTL       int             `json:"generator_ttl" yaml:"generator_ttl,omitempty" toml:"generator_ttl,omitempty,omitzero"`
+	IsPresentOnCmdLine bool            `json:"-" yaml:"-" toml:"-"`
+	Opts               []BceCommandOpt `json:"opts" yaml:"opts,omitempty" toml:"opts,omitempty"`
 }
 
+// BceCommandOpt is a value of an OPTION arg (or positional, in which case ArgUuid is the positional's Uuid)
 type BceCommandOpt struct {
-	Uuid    string `json:"uuid"`
-	ArgUuid string `json:"-"`
-	Name    string `json:"name"`
+	Uuid    string `json:"uuid" yaml:"uuid" toml:"uuid"`
+	ArgUuid string `json:"-" yaml:"-" toml:"-"`
+	Name    string `json:"name" yaml:"name" toml:"name"`
 }
 
-func DBQueryCommand(conn *sql.DB, cmdName string) (*BceCommand, error) {
+// Arity of a positional
+const (
+	ArityExactly  = "EXACTLY"
+	ArityOptional = "OPTIONAL"
+	ArityVariadic = "VARIADIC"
+)
+
+// BceCommandPositional is an argument identified by its position (starting at 1) after the command,
+// rather than by a name, e.g. the POD in `kubectl logs POD [CONTAINER]`
+type BceCommandPositional struct {
+	Uuid         string          `json:"uuid" yaml:"uuid" toml:"uuid"`
+	CmdUuid      string          `json:"-" yaml:"-" toml:"-"`
+	Position     int             `json:"position" yaml:"position" toml:"position"`
+	Name         string          `json:"name" yaml:"name" toml:"name"`
+	Arity        string          `json:"arity" yaml:"arity" toml:"arity"`
+	ArgType      string          `json:"arg_type" yaml:"arg_type" toml:"arg_type"`
+	Description  string          `json:"description" yaml:"description" toml:"description"`
+	FileFilter   string          `json:"file_filter" yaml:"file_filter,omitempty" toml:"file_filter,omitempty"`
+	Generator    string          `json:"generator" yaml:"generator,omitempty" toml:"generator,omitempty"`
+	GeneratorTTL int             `json:"generator_ttl" yaml:"generator_ttl,omitempty" toml:"generator_ttl,omitempty,omitzero"`
+	Opts         []BceCommandOpt `json:"opts" yaml:"opts,omitempty" toml:"opts,omitempty"`
+}
+
+// DBQueryCommand loads a root command (by name or alias) and all of its descendents, returning nil if it doesn't exist
+func DBQueryCommand(conn BceDBConn, cmdName string) (*BceCommand, error) {
 	var cmd BceCommand
 
 	stmt, err := conn.Prepare(sqlReadCommand)
@@ -127,30 +211,19 @@ func DBQueryCommand(conn *sql.DB, cmdName string) (*BceCommand, error) {
 	}
 	defer rows.Close()
 
-	if rows.Next() {
-		err = rows.Scan(&cmd.Uuid, &cmd.Name, &cmd.ParentCmdUuid)
-		if err != nil {
-			return nil, err
-		}
-	}
-
-	err = cmd.QueryAliases(conn)
-	if err != nil {
-		return nil, err
+	if !rows.Next() {
+		return nil, rows.Err()
 	}
-	err = cmd.QuerySubCommands(conn)
-	if err != nil {
-		return nil, err
-	}
-	err = cmd.QueryArgs(conn)
+	err = rows.Scan(&cmd.Uuid, &cmd.Name, &cmd.ParentCmdUuid)
 	if err != nil {
 		return nil, err
 	}
+	rows.Close()
 
-	return &cmd, nil
+	return DBQueryCommandTree(conn, cmd.Uuid)
 }
 
-func (cmd *BceCommand) QueryAliases(conn *sql.DB) error {
+func (cmd *BceCommand) QueryAliases(conn BceDBConn) error {
 	cmd.Aliases = nil
 
 	stmt, err := conn.Prepare(sqlReadCommandAliases)
@@ -177,10 +250,10 @@ func (cmd *BceCommand) QueryAliases(conn *sql.DB) error {
 	return nil
 }
 
-func (cmd *BceCommand) QuerySubCommands(conn *sql.DB) error {
-	cmd.SubCommands = nil
+func (cmd *BceCommand) QueryArgs(conn BceDBConn) error {
+	cmd.Args = nil
 
-	stmt, err := conn.Prepare(sqlReadSubCommands)
+	stmt, err := conn.Prepare(sqlReadCommandArgs)
 	if err != nil {
 		return err
 	}
@@ -193,40 +266,53 @@ func (cmd *BceCommand) QuerySubCommands(conn *sql.DB) error {
 	defer rows.Close()
 
 	for rows.Next() {
-		var subCmd BceCommand
-		err = rows.Scan(&subCmd.Uuid, &subCmd.Name, &subCmd.ParentCmdUuid)
+		var arg BceCommandArg
+		// ca.Uuid, ca.cmd_uuid, ca.arg_type, ca.Description, ca.long_name, ca.short_name, ca.file_filter
+		err := rows.Scan(&arg.Uuid, &arg.CmdUuid, &arg.ArgType, &arg.Description, &arg.LongName, &arg.ShortName, &arg.FileFilter, &arg.Generator, &arg.GeneratorTTL)
 		if err != nil {
 			return err
 		}
-
-		// populate child Aliases
-		err = subCmd.QueryAliases(conn)
+		err = arg.QueryOpts(conn)
 		if err != nil {
 			return err
 		}
+		cmd.Args = append(cmd.Args, arg)
+	}
 
-		// populate child Args
-		err = subCmd.QueryArgs(conn)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		// populate child sub-cmds
-		err = subCmd.QuerySubCommands(conn)
+func (arg *BceCommandArg) QueryOpts(conn BceDBConn) error {
+	arg.Opts = nil
+
+	stmt, err := conn.Prepare(sqlReadCommandOpts)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query(arg.Uuid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var opt BceCommandOpt
+		err := rows.Scan(&opt.Uuid, &opt.ArgUuid, &opt.Name)
 		if err != nil {
 			return err
 		}
-
-		cmd.SubCommands = append(cmd.SubCommands, subCmd)
+		arg.Opts = append(arg.Opts, opt)
 	}
 
 	return nil
 }
 
-func (cmd *BceCommand) QueryArgs(conn *sql.DB) error {
-	cmd.Args = nil
+func (cmd *BceCommand) QueryPositionals(conn BceDBConn) error {
+	cmd.Positionals = nil
 
-	stmt, err := conn.Prepare(sqlReadCommandArgs)
+	stmt, err := conn.Prepare(sqlReadCommandPositionals)
 	if err != nil {
 		return err
 	}
@@ -239,32 +325,32 @@ func (cmd *BceCommand) QueryArgs(conn *sql.DB) error {
 	defer rows.Close()
 
 	for rows.Next() {
-		var arg BceCommandArg
-		// ca.Uuid, ca.cmd_uuid, ca.arg_type, ca.Description, ca.long_name, ca.short_name
-		err := rows.Scan(&arg.Uuid, &arg.CmdUuid, &arg.ArgType, &arg.Description, &arg.LongName, &arg.ShortName)
+		var positional BceCommandPositional
+		err := rows.Scan(&positional.Uuid, &positional.CmdUuid, &positional.Position, &positional.Name, &positional.Arity,
+			&positional.ArgType, &positional.Description, &positional.FileFilter, &positional.Generator, &positional.GeneratorTTL)
 		if err != nil {
 			return err
 		}
-		err = arg.QueryOpts(conn)
+		err = positional.QueryOpts(conn)
 		if err != nil {
 			return err
 		}
-		cmd.Args = append(cmd.Args, arg)
+		cmd.Positionals = append(cmd.Positionals, positional)
 	}
 
 	return nil
 }
 
-func (arg *BceCommandArg) QueryOpts(conn *sql.DB) error {
-	arg.Opts = nil
+func (positional *BceCommandPositional) QueryOpts(conn BceDBConn) error {
+	positional.Opts = nil
 
-	stmt, err := conn.Prepare(sqlReadCommandOpts)
+	stmt, err := conn.Prepare(sqlReadPositionalOpts)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(arg.Uuid)
+	rows, err := stmt.Query(positional.Uuid)
 	if err != nil {
 		return err
 	}
@@ -276,13 +362,13 @@ func (arg *BceCommandArg) QueryOpts(conn *sql.DB) error {
 		if err != nil {
 			return err
 		}
-		arg.Opts = append(arg.Opts, opt)
+		positional.Opts = append(positional.Opts, opt)
 	}
 
 	return nil
 }
 
-func DBQueryRootCommandNames(conn *sql.DB) ([]string, error) {
+func DBQueryRootCommandNames(conn BceDBConn) ([]string, error) {
 	var cmdNames []string
 
 	stmt, err := conn.Prepare(sqlReadRootCommandNames)
@@ -309,7 +395,62 @@ func DBQueryRootCommandNames(conn *sql.DB) ([]string, error) {
 	return cmdNames, nil
 }
 
-func (cmd *BceCommand) InsertDB(conn *sql.DB) error {
+// DBQueryRootCommandAliases returns the aliases of the top-level commands, keyed by command name
+func DBQueryRootCommandAliases(conn BceDBConn) (map[string][]string, error) {
+	var aliasNames = make(map[string][]string)
+
+	stmt, err := conn.Prepare(sqlReadRootCommandAliases)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cmdName, aliasName string
+		err = rows.Scan(&cmdName, &aliasName)
+		if err != nil {
+			return nil, err
+		}
+		aliasNames[cmdName] = append(aliasNames[cmdName], aliasName)
+	}
+
+	return aliasNames, nil
+}
+
+func DBQueryCommandTombstones(conn BceDBConn) ([]string, error) {
+	var cmdNames []string
+
+	stmt, err := conn.Prepare(sqlReadCommandTombstones)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	rows, err := stmt.Query()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cmdName string
+		err = rows.Scan(&cmdName)
+		if err != nil {
+			return nil, err
+		}
+		cmdNames = append(cmdNames, cmdName)
+	}
+
+	return cmdNames, nil
+}
+
+func (cmd *BceCommand) InsertDB(conn BceDBConn) error {
 	// insert the command
 	stmt, err := conn.Prepare(sqlWriteCommand)
 	if err == nil {
@@ -336,10 +477,26 @@ func (cmd *BceCommand) InsertDB(conn *sql.DB) error {
 		}
 	}
 
+	// insert the positionals
+	for _, positional := range cmd.Positionals {
+		err = positional.InsertDB(conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	// insert the sub-commands (recursively)
+	for _, subCmd := range cmd.SubCommands {
+		err = subCmd.InsertDB(conn)
+		if err != nil {
+			return err
+		}
+	}
+
 	return err
 }
 
-func (alias *BceCommandAlias) InsertDB(conn *sql.DB) error {
+func (alias *BceCommandAlias) InsertDB(conn BceDBConn) error {
 	// insert the alias
 	stmt, err := conn.Prepare(sqlWriteCommandAlias)
 	if err == nil {
@@ -349,12 +506,12 @@ func (alias *BceCommandAlias) InsertDB(conn *sql.DB) error {
 	return err
 }
 
-func (arg *BceCommandArg) InsertDB(conn *sql.DB) error {
+func (arg *BceCommandArg) InsertDB(conn BceDBConn) error {
 	// insert the arg
 	stmt, err := conn.Prepare(sqlWriteCommandArg)
 	if err == nil {
 		defer stmt.Close()
-		_, err = stmt.Exec(arg.Uuid, arg.CmdUuid, arg.ArgType, arg.Description, arg.LongName, arg.ShortName)
+		_, err = stmt.Exec(arg.Uuid, arg.CmdUuid, arg.ArgType, arg.Description, arg.LongName, arg.ShortName, arg.FileFilter, arg.Generator, arg.GeneratorTTL)
 	}
 	if err != nil {
 		return err
@@ -370,7 +527,7 @@ func (arg *BceCommandArg) InsertDB(conn *sql.DB) error {
 	return err
 }
 
-func (opt *BceCommandOpt) InsertDB(conn *sql.DB) error {
+func (opt *BceCommandOpt) InsertDB(conn BceDBConn) error {
 	// insert the opt
 	stmt, err := conn.Prepare(sqlWriteCommandOpt)
 	if err == nil {
@@ -380,7 +537,43 @@ func (opt *BceCommandOpt) InsertDB(conn *sql.DB) error {
 	return err
 }
 
-func DBDeleteCommand(conn *sql.DB, commandName string) error {
+func (positional *BceCommandPositional) InsertDB(conn BceDBConn) error {
+	// insert the positional
+	stmt, err := conn.Prepare(sqlWriteCommandPositional)
+	if err == nil {
+		defer stmt.Close()
+		_, err = stmt.Exec(positional.Uuid, positional.CmdUuid, positional.Position, positional.Name, positional.Arity,
+			positional.ArgType, positional.Description, positional.FileFilter, positional.Generator, positional.GeneratorTTL)
+	}
+	if err != nil {
+		return err
+	}
+
+	// insert the opts
+	for _, opt := range positional.Opts {
+		stmt, err := conn.Prepare(sqlWritePositionalOpt)
+		if err == nil {
+			_, err = stmt.Exec(opt.Uuid, opt.ArgUuid, opt.Name)
+			stmt.Close()
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReplaceDB replaces the root command of the same name (if any) with this command
+func (cmd *BceCommand) ReplaceDB(conn BceDBConn) error {
+	// delete the command (cascading) if it exists
+	err := DBDeleteCommand(conn, cmd.Name)
+	if err != nil {
+		return err
+	}
+	return cmd.InsertDB(conn)
+}
+
+func DBDeleteCommand(conn BceDBConn, commandName string) error {
 	// delete the command (cascade to children)
 	stmt, err := conn.Prepare(sqlDeleteCommand)
 	if err == nil {
@@ -389,3 +582,22 @@ func DBDeleteCommand(conn *sql.DB, commandName string) error {
 	}
 	return err
 }
+
+// DBInsertCommandTombstone hides the command from the layers below this database
+func DBInsertCommandTombstone(conn BceDBConn, commandName string) error {
+	stmt, err := conn.Prepare(sqlWriteCommandTombstone)
+	if err == nil {
+		defer stmt.Close()
+		_, err = stmt.Exec(commandName)
+	}
+	return err
+}
+
+func DBDeleteCommandTombstone(conn BceDBConn, commandName string) error {
+	stmt, err := conn.Prepare(sqlDeleteCommandTombstone)
+	if err == nil {
+		defer stmt.Close()
+		_, err = stmt.Exec(commandName)
+	}
+	return err
+}
@@ -1,11 +1,38 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
 	"strconv"
 )
 
-const DBSchemaVersion = 1
+const DBSchemaVersion = 8
+
+const DBFilename = "completion.db"
+
+// DBPathEnvVar overrides the location of the user database
+const DBPathEnvVar = "BCE_DB"
+
+// DBSystemPathEnvVar overrides the location of the system database
+const DBSystemPathEnvVar = "BCE_SYSTEM_DB"
+
+// DBSystemDir holds the system-wide database, shared by all users
+const DBSystemDir = "/usr/share/bce"
+
+// BceDBConn is a database or a transaction, so the queries and writes of a command run in either
+type BceDBConn interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+	Prepare(query string) (*sql.Stmt, error)
+}
+
+// DBPathFlag is the user database location given on the command line (--db), which takes precedence over everything else
+var DBPathFlag string
 
 const sqlCreateCompletionCommand = ` 
 	CREATE TABLE IF NOT EXISTS command (
@@ -15,7 +42,7 @@ const sqlCreateCompletionCommand = `
       FOREIGN KEY(parent_cmd) REFERENCES command(Uuid) ON DELETE CASCADE
     );
 	CREATE UNIQUE INDEX command_name_idx
- 		ON command (Name);
+ 		ON command (COALESCE(parent_cmd, ''), Name);
 	CREATE INDEX command_parent_idx
 		ON command (parent_cmd); `
 
@@ -38,10 +65,13 @@ const sqlCreateCompletionCommandArg = `
 		Uuid TEXT PRIMARY KEY,
         cmd_uuid TEXT NOT NULL,
         arg_type TEXT NOT NULL
-        	CHECK (arg_type IN ('NONE', 'OPTION', 'FILE', 'TEXT')),
+        	CHECK (arg_type IN ('NONE', 'OPTION', 'FILE', 'DIRECTORY', 'TEXT', 'GENERATOR')),
         Description TEXT NOT NULL, 
         long_name TEXT, 
         short_name TEXT, 
+        file_filter TEXT, 
+        generator TEXT, 
+        generator_ttl INTEGER, 
         FOREIGN KEY(cmd_uuid) REFERENCES command(Uuid) ON DELETE CASCADE, 
         CHECK ( (long_name IS NOT NULL) OR (short_name IS NOT NULL) ) 
 	); 
@@ -64,6 +94,64 @@ const sqlCreateCompletionCommandOpt = `
         ON command_opt (cmd_arg_uuid, Name); 
 `
 
+const sqlCreateCompletionCommandPositional = `
+	CREATE TABLE IF NOT EXISTS command_positional (
+		uuid TEXT PRIMARY KEY,
+		cmd_uuid TEXT NOT NULL,
+		position INTEGER NOT NULL
+			CHECK (position > 0),
+		name TEXT NOT NULL,
+		arity TEXT NOT NULL
+			CHECK (arity IN ('EXACTLY', 'OPTIONAL', 'VARIADIC')),
+		arg_type TEXT NOT NULL
+			CHECK (arg_type IN ('OPTION', 'FILE', 'DIRECTORY', 'TEXT', 'GENERATOR')),
+		description TEXT NOT NULL,
+		file_filter TEXT,
+		generator TEXT,
+		generator_ttl INTEGER,
+		FOREIGN KEY(cmd_uuid) REFERENCES command(uuid) ON DELETE CASCADE
+	);
+	CREATE INDEX command_positional_cmd_uuid_idx
+		ON command_positional (cmd_uuid);
+	CREATE UNIQUE INDEX command_positional_position_idx
+		ON command_positional (cmd_uuid, position);
+
+	CREATE TABLE IF NOT EXISTS command_positional_opt (
+		uuid TEXT PRIMARY KEY,
+		positional_uuid TEXT NOT NULL,
+		name TEXT NOT NULL,
+		FOREIGN KEY(positional_uuid) REFERENCES command_positional(uuid) ON DELETE CASCADE
+	);
+	CREATE INDEX command_positional_opt_positional_idx
+		ON command_positional_opt (positional_uuid);
+	CREATE UNIQUE INDEX command_positional_opt_name_idx
+		ON command_positional_opt (positional_uuid, name);
+`
+
+const sqlCreateCompletionCommandTombstone = `
+	CREATE TABLE IF NOT EXISTS command_tombstone (
+		name TEXT PRIMARY KEY
+	);
+`
+
+// DBUserPath returns the user's database, in $XDG_DATA_HOME (defaulting to ~/.local/share)
+func DBUserPath() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if len(dataHome) == 0 {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "bce", DBFilename), nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
 func DBOpen(filename string) (*sql.DB, error) {
 	conn, err := sql.Open("sqlite3", filename)
 	if err != nil {
@@ -85,10 +173,74 @@ func DBOpen(filename string) (*sql.DB, error) {
 	return conn, nil
 }
 
+// DBOpenReadOnly opens an existing database without writing to it: its schema isn't created or migrated,
+// and its journal mode is left alone, so it can be read by a user who can't write to it (or its directory)
+func DBOpenReadOnly(filename string) (*sql.DB, error) {
+	uri := "file:" + (&url.URL{Path: filename}).EscapedPath() + "?mode=ro"
+	conn, err := dbOpenUri(uri)
+	if (err != nil) && !fileExists(filename+"-wal") {
+		// a WAL database needs write access to its directory (for the -shm file), unless it is opened as immutable,
+		// which is safe while it has no -wal file (nothing waiting to be checkpointed)
+		conn, err = dbOpenUri(uri + "&immutable=1")
+	}
+	return conn, err
+}
+
+func dbOpenUri(uri string) (*sql.DB, error) {
+	conn, err := sql.Open("sqlite3", uri)
+	if err != nil {
+		return nil, err
+	}
+
+	// the connection is opened lazily, so read something to be sure the database can be read
+	_, err = conn.Exec("PRAGMA foreign_keys = 1;")
+	if err == nil {
+		_, err = conn.Exec("SELECT COUNT(*) FROM sqlite_master;")
+	}
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+
+	return conn, nil
+}
+
 func DBClose(conn *sql.DB) {
 	_ = conn.Close()
 }
 
+// DBFilePath returns the file of the database, empty for an in-memory or temporary database
+func DBFilePath(conn *sql.DB) (string, error) {
+	var seq int
+	var name, path string
+	err := conn.QueryRow("PRAGMA database_list;").Scan(&seq, &name, &path)
+	return path, err
+}
+
+// DBBegin starts a transaction on a connection of its own, which the caller closes once the transaction is over.
+// (BEGIN and COMMIT executed on the database may each run on a different pooled connection, and a pooled connection
+// opened after DBOpen doesn't enforce the foreign keys, which the deletes cascade through.)
+func DBBegin(conn *sql.DB) (*sql.Conn, *sql.Tx, error) {
+	ctx := context.Background()
+	dbConn, err := conn.Conn(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	_, err = dbConn.ExecContext(ctx, "PRAGMA foreign_keys = 1;")
+	if err != nil {
+		_ = dbConn.Close()
+		return nil, nil, err
+	}
+
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		_ = dbConn.Close()
+		return nil, nil, err
+	}
+	return dbConn, tx, nil
+}
+
 func DBGetSchemaVersion(conn *sql.DB) (int, error) {
 	var version int
 
@@ -127,7 +279,49 @@ func DBCreateSchema(conn *sql.DB) error {
 		return err
 	}
 
+	_, err = conn.Exec(sqlCreateCompletionCommandPositional)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(sqlCreateCompletionCommandTombstone)
+	if err != nil {
+		return err
+	}
+
+	_, err = conn.Exec(sqlCreateCommandRevision)
+	if err != nil {
+		return err
+	}
+
 	query := "PRAGMA user_version = " + strconv.Itoa(DBSchemaVersion) + ";"
 	_, err = conn.Exec(query)
 	return err
 }
+
+// DBEnsureSchema creates the schema in a new database and upgrades an older one,
+// but refuses a database created by a newer version of bce
+func DBEnsureSchema(conn *sql.DB) error {
+	schemaVersion, err := DBGetSchemaVersion(conn)
+	if err != nil {
+		return err
+	}
+	if schemaVersion == 0 {
+		// create the schema
+		err = DBCreateSchema(conn)
+		if err != nil {
+			return err
+		}
+		schemaVersion, err = DBGetSchemaVersion(conn)
+		if err != nil {
+			return err
+		}
+	}
+	if schemaVersion > DBSchemaVersion {
+		return fmt.Errorf("database schema version %d is newer than the supported version %d, please upgrade bce", schemaVersion, DBSchemaVersion)
+	}
+	if schemaVersion < DBSchemaVersion {
+		return DBMigrate(conn, schemaVersion)
+	}
+	return nil
+}
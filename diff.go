@@ -0,0 +1,344 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strconv"
+	"strings"
+)
+
+// kinds of change
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// output formats of --diff
+const (
+	DiffOutputText = "text"
+	DiffOutputJson = "json"
+)
+
+// BceChange is a difference between two versions of a command: an added or removed node, or a changed attribute of one
+type BceChange struct {
+	Change string `json:"change"`
+	// Kind is the kind of node: command, alias, arg, opt or positional
+	Kind string `json:"kind"`
+	// Command is the path of the command, e.g. "kubectl get"
+	Command string `json:"command"`
+	// Name identifies the node within the command (empty for the command itself), e.g. --output, or --output=yaml for an opt
+	Name      string `json:"name,omitempty"`
+	Attribute string `json:"attribute,omitempty"`
+	Old       string `json:"old,omitempty"`
+	New       string `json:"new,omitempty"`
+}
+
+// diffCommand lists the differences from one version of a root command to another (either may be nil).
+// Nodes are matched by uuid, then by name (args by long name, then short name, and positionals by position).
+func diffCommand(from *BceCommand, to *BceCommand) []BceChange {
+	if (from == nil) && (to == nil) {
+		return nil
+	}
+	if from == nil {
+		return []BceChange{{Change: ChangeAdded, Kind: "command", Command: to.Name}}
+	}
+	if to == nil {
+		return []BceChange{{Change: ChangeRemoved, Kind: "command", Command: from.Name}}
+	}
+	return diffSubCommand(from.Name, from, to)
+}
+
+func diffSubCommand(path string, from *BceCommand, to *BceCommand) []BceChange {
+	var changes []BceChange
+	if from.Name != to.Name {
+		changes = append(changes, BceChange{Change: ChangeChanged, Kind: "command", Command: path, Attribute: "name", Old: from.Name, New: to.Name})
+	}
+
+	// aliases, by name
+	for _, alias := range to.Aliases {
+		if !hasAlias(from.Aliases, alias.Name) {
+			changes = append(changes, BceChange{Change: ChangeAdded, Kind: "alias", Command: path, Name: alias.Name})
+		}
+	}
+	for _, alias := range from.Aliases {
+		if !hasAlias(to.Aliases, alias.Name) {
+			changes = append(changes, BceChange{Change: ChangeRemoved, Kind: "alias", Command: path, Name: alias.Name})
+		}
+	}
+
+	matched := map[int]bool{}
+	for _, arg := range to.Args {
+		i := matchArg(from.Args, arg, matched)
+		if i < 0 {
+			changes = append(changes, BceChange{Change: ChangeAdded, Kind: "arg", Command: path, Name: argLabel(arg)})
+			continue
+		}
+		matched[i] = true
+		old := from.Args[i]
+		label := argLabel(arg)
+		changes = appendAttributeChanges(changes, "arg", path, label, [][3]string{
+			{"long_name", old.LongName, arg.LongName},
+			{"short_name", old.ShortName, arg.ShortName},
+			{"arg_type", old.ArgType, arg.ArgType},
+			{"description", old.Description, arg.Description},
+			{"file_filter", old.FileFilter, arg.FileFilter},
+			{"generator", old.Generator, arg.Generator},
+			{"generator_ttl", strconv.Itoa(old.GeneratorTTL), strconv.Itoa(arg.GeneratorTTL)},
+		})
+		changes = append(changes, diffOpts(path, label, old.Opts, arg.Opts)...)
+	}
+	for i, arg := range from.Args {
+		if !matched[i] {
+			changes = append(changes, BceChange{Change: ChangeRemoved, Kind: "arg", Command: path, Name: argLabel(arg)})
+		}
+	}
+
+	matched = map[int]bool{}
+	for _, positional := range to.Positionals {
+		i := matchPositional(from.Positionals, positional, matched)
+		if i < 0 {
+			changes = append(changes, BceChange{Change: ChangeAdded, Kind: "positional", Command: path, Name: positionalLabel(positional)})
+			continue
+		}
+		matched[i] = true
+		old := from.Positionals[i]
+		label := positionalLabel(positional)
+		changes = appendAttributeChanges(changes, "positional", path, label, [][3]string{
+			{"position", strconv.Itoa(old.Position), strconv.Itoa(positional.Position)},
+			{"name", old.Name, positional.Name},
+			{"arity", old.Arity, positional.Arity},
+			{"arg_type", old.ArgType, positional.ArgType},
+			{"description", old.Description, positional.Description},
+			{"file_filter", old.FileFilter, positional.FileFilter},
+			{"generator", old.Generator, positional.Generator},
+			{"generator_ttl", strconv.Itoa(old.GeneratorTTL), strconv.Itoa(positional.GeneratorTTL)},
+		})
+		changes = append(changes, diffOpts(path, label, old.Opts, positional.Opts)...)
+	}
+	for i, positional := range from.Positionals {
+		if !matched[i] {
+			changes = append(changes, BceChange{Change: ChangeRemoved, Kind: "positional", Command: path, Name: positionalLabel(positional)})
+		}
+	}
+
+	matched = map[int]bool{}
+	for i := range to.SubCommands {
+		subCmd := &to.SubCommands[i]
+		j := matchSubCommand(from.SubCommands, subCmd, matched)
+		if j < 0 {
+			changes = append(changes, BceChange{Change: ChangeAdded, Kind: "command", Command: path + " " + subCmd.Name})
+			continue
+		}
+		matched[j] = true
+		changes = append(changes, diffSubCommand(path+" "+from.SubCommands[j].Name, &from.SubCommands[j], subCmd)...)
+	}
+	for i, subCmd := range from.SubCommands {
+		if !matched[i] {
+			changes = append(changes, BceChange{Change: ChangeRemoved, Kind: "command", Command: path + " " + subCmd.Name})
+		}
+	}
+	return changes
+}
+
+// appendAttributeChanges adds the attributes (name, old value, new value) which differ
+func appendAttributeChanges(changes []BceChange, kind string, path string, name string, attributes [][3]string) []BceChange {
+	for _, attribute := range attributes {
+		if attribute[1] != attribute[2] {
+			changes = append(changes, BceChange{Change: ChangeChanged, Kind: kind, Command: path, Name: name,
+				Attribute: attribute[0], Old: attribute[1], New: attribute[2]})
+		}
+	}
+	return changes
+}
+
+func diffOpts(path string, label string, from []BceCommandOpt, to []BceCommandOpt) []BceChange {
+	var changes []BceChange
+	for _, opt := range to {
+		if !hasOpt(from, opt.Name) {
+			changes = append(changes, BceChange{Change: ChangeAdded, Kind: "opt", Command: path, Name: label + "=" + opt.Name})
+		}
+	}
+	for _, opt := range from {
+		if !hasOpt(to, opt.Name) {
+			changes = append(changes, BceChange{Change: ChangeRemoved, Kind: "opt", Command: path, Name: label + "=" + opt.Name})
+		}
+	}
+	return changes
+}
+
+func hasAlias(aliases []BceCommandAlias, name string) bool {
+	for _, alias := range aliases {
+		if alias.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func hasOpt(opts []BceCommandOpt, name string) bool {
+	for _, opt := range opts {
+		if opt.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// matchArg finds the (unmatched) arg with the same uuid, then long name, then short name (without a long name)
+func matchArg(args []BceCommandArg, arg BceCommandArg, matched map[int]bool) int {
+	for i := range args {
+		if !matched[i] && (args[i].Uuid == arg.Uuid) {
+			return i
+		}
+	}
+	for i := range args {
+		if matched[i] {
+			continue
+		}
+		if (len(arg.LongName) > 0) && (args[i].LongName == arg.LongName) {
+			return i
+		}
+		if (len(arg.LongName) == 0) && (len(args[i].LongName) == 0) && (args[i].ShortName == arg.ShortName) {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchPositional finds the (unmatched) positional with the same uuid, then position
+func matchPositional(positionals []BceCommandPositional, positional BceCommandPositional, matched map[int]bool) int {
+	for i := range positionals {
+		if !matched[i] && (positionals[i].Uuid == positional.Uuid) {
+			return i
+		}
+	}
+	for i := range positionals {
+		if !matched[i] && (positionals[i].Position == positional.Position) {
+			return i
+		}
+	}
+	return -1
+}
+
+// matchSubCommand finds the (unmatched) sub-command with the same uuid, then name
+func matchSubCommand(subCmds []BceCommand, subCmd *BceCommand, matched map[int]bool) int {
+	for i := range subCmds {
+		if !matched[i] && (subCmds[i].Uuid == subCmd.Uuid) {
+			return i
+		}
+	}
+	for i := range subCmds {
+		if !matched[i] && (subCmds[i].Name == subCmd.Name) {
+			return i
+		}
+	}
+	return -1
+}
+
+func argLabel(arg BceCommandArg) string {
+	if len(arg.LongName) > 0 {
+		return arg.LongName
+	}
+	return arg.ShortName
+}
+
+func positionalLabel(positional BceCommandPositional) string {
+	return strconv.Itoa(positional.Position) + ":" + positional.Name
+}
+
+// printChanges writes the changes for a reader, one per line: + added, - removed, ~ changed
+func printChanges(out io.Writer, changes []BceChange) {
+	for _, change := range changes {
+		var symbol string
+		switch change.Change {
+		case ChangeAdded:
+			symbol = "+"
+		case ChangeRemoved:
+			symbol = "-"
+		default:
+			symbol = "~"
+		}
+		location := strings.TrimSpace(change.Command + " " + change.Name)
+		if change.Change == ChangeChanged {
+			fmt.Fprintf(out, "%s %s %s: %s %q -> %q\n", symbol, change.Kind, location, change.Attribute, change.Old, change.New)
+		} else {
+			fmt.Fprintf(out, "%s %s %s\n", symbol, change.Kind, location)
+		}
+	}
+}
+
+// processDiff compares a command (or every command, for ExportAllCommands) of a spec file or database file with the
+// one in the database (the layer's, or the one visible through the layers), writing what importing the file would change
+func processDiff(commandName string, filename string, format string, layer string, output string, out io.Writer) error {
+	if (output != DiffOutputText) && (output != DiffOutputJson) {
+		return errors.New("unknown diff output: " + output + " (text, json)")
+	}
+
+	var srcCmds []*BceCommand
+	var err error
+	if format == FormatSqlite {
+		srcCmds, err = querySqliteCommands(filename)
+	} else if isSpecFormat(format) {
+		var data []byte
+		data, err = ioutil.ReadFile(filename)
+		if err == nil {
+			srcCmds, err = parseSpecBundle(data, format)
+		}
+	} else {
+		return errors.New("unknown diff format: " + format + " (sqlite, json, yaml, toml)")
+	}
+	if err != nil {
+		return err
+	}
+
+	if commandName != ExportAllCommands {
+		var found []*BceCommand
+		for _, cmd := range srcCmds {
+			if cmd.Name == commandName {
+				found = append(found, cmd)
+			}
+		}
+		if len(found) == 0 {
+			return errors.New("command not found in " + filename + ": " + commandName)
+		}
+		srcCmds = found
+	}
+
+	layers, err := DBOpenLayerOrLayers(layer)
+	if err != nil {
+		return err
+	}
+	defer DBCloseLayers(layers)
+
+	var changes = []BceChange{}
+	for _, srcCmd := range srcCmds {
+		cmd, err := DBQueryCommandLayered(layers, srcCmd.Name)
+		if err != nil {
+			return err
+		}
+		if (cmd != nil) && (cmd.Name != srcCmd.Name) {
+			// an alias of another command, which an import doesn't replace
+			cmd = nil
+		}
+		cmdChanges := diffCommand(cmd, srcCmd)
+		if (output == DiffOutputText) && (len(cmdChanges) == 0) {
+			fmt.Fprintf(out, "= command %s: no changes\n", srcCmd.Name)
+		}
+		changes = append(changes, cmdChanges...)
+	}
+
+	if output == DiffOutputJson {
+		data, err := json.MarshalIndent(changes, "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(out, string(data))
+		return err
+	}
+	printChanges(out, changes)
+	return nil
+}
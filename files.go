@@ -0,0 +1,121 @@
+package main
+
+import (
+	"log"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+)
+
+// FileFilterSeparator separates the glob patterns stored in BceCommandArg.FileFilter (e.g. "*.yaml,*.yml")
+const FileFilterSeparator = ","
+
+// CollectFileRecommendations lists the filesystem entries which complete the current word.
+// Directories are suffixed with a slash, so completion can continue into them. Files are only
+// included when dirsOnly is false, and must match one of the glob patterns in filter (if any).
+func CollectFileRecommendations(input *BashInput, filter string, dirsOnly bool) []BceRecommendation {
+	var results []BceRecommendation
+
+	var word string
+	if input.CurrentWord != nil {
+		word = *input.CurrentWord
+	}
+
+	// a bare ~ or ~user is completed to its home directory
+	if strings.HasPrefix(word, "~") && !strings.Contains(word, "/") {
+		if expandTilde(word) != word {
+			results = append(results, BceRecommendation{Name: word + "/"})
+		}
+		return results
+	}
+
+	// split the word into the directory to read and the partial filename
+	dir, partial := filepath.Split(word)
+	readDir := expandTilde(dir)
+	if len(readDir) == 0 {
+		readDir = "."
+	}
+	// relative to the shell's directory (which isn't the daemon's)
+	if !filepath.IsAbs(readDir) && (len(input.Dir) > 0) {
+		readDir = filepath.Join(input.Dir, readDir)
+	}
+
+	entries, err := os.ReadDir(readDir)
+	if err != nil {
+		log.Println("Unable to read directory:", err)
+		return results
+	}
+
+	var patterns []string
+	if len(filter) > 0 {
+		patterns = strings.Split(filter, FileFilterSeparator)
+	}
+
+	for _, entry := range entries {
+		name := entry.Name()
+		// hidden files are only offered when the partial filename asks for them
+		if strings.HasPrefix(name, ".") && !strings.HasPrefix(partial, ".") {
+			continue
+		}
+		if _, ok := input.MatchPrefix(partial, name); !ok {
+			continue
+		}
+		// follow symlinks, so a link to a directory is treated as a directory
+		info, err := os.Stat(filepath.Join(readDir, name))
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			results = append(results, BceRecommendation{Name: dir + name + "/"})
+		} else if !dirsOnly && matchesFileFilter(name, patterns) {
+			results = append(results, BceRecommendation{Name: dir + name})
+		}
+	}
+
+	return results
+}
+
+func matchesFileFilter(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		matched, err := filepath.Match(strings.TrimSpace(pattern), name)
+		if err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// expandTilde replaces a leading ~ or ~user with the corresponding home directory
+func expandTilde(path string) string {
+	if !strings.HasPrefix(path, "~") {
+		return path
+	}
+
+	var userName, rest string
+	if idx := strings.Index(path, "/"); idx >= 0 {
+		userName = path[1:idx]
+		rest = path[idx:]
+	} else {
+		userName = path[1:]
+	}
+
+	var home string
+	if len(userName) == 0 {
+		dir, err := os.UserHomeDir()
+		if err != nil {
+			return path
+		}
+		home = dir
+	} else {
+		u, err := user.Lookup(userName)
+		if err != nil {
+			return path
+		}
+		home = u.HomeDir
+	}
+	return home + rest
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+	"io"
+	"path/filepath"
+	"strings"
+)
+
+// file formats of --format
+const (
+	FormatSqlite = "sqlite"
+	FormatJson   = "json"
+	FormatYaml   = "yaml"
+	FormatToml   = "toml"
+	FormatIndex  = "index"
+)
+
+// SpecFormats are the formats of hand-authored specs, which share the data model (and validation) of the JSON specs
+var SpecFormats = []string{FormatJson, FormatYaml, FormatToml}
+
+func isSpecFormat(format string) bool {
+	return contains(SpecFormats, format)
+}
+
+// specFileFormat is the spec format of a file: the format given (if it is one), otherwise its extension's
+func specFileFormat(filename string, format string) string {
+	if isSpecFormat(format) {
+		return format
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".yaml", ".yml":
+		return FormatYaml
+	case ".toml":
+		return FormatToml
+	}
+	return FormatJson
+}
+
+// parseSpecBundle converts a spec, or a bundle of specs, into data model objects.
+// A YAML bundle is a sequence of specs, or a stream of documents. A TOML bundle is an array of command tables ([[command]]).
+func parseSpecBundle(data []byte, format string) ([]*BceCommand, error) {
+	switch format {
+	case FormatJson:
+		return parseJsonBundle(data)
+	case FormatYaml:
+		return parseYamlBundle(data)
+	case FormatToml:
+		return parseTomlBundle(data)
+	}
+	return nil, errors.New("unknown spec format: " + format + " (" + strings.Join(SpecFormats, ", ") + ")")
+}
+
+func parseYamlBundle(data []byte) ([]*BceCommand, error) {
+	var specs []interface{}
+	decoder := yaml.NewDecoder(bytes.NewReader(data))
+	for {
+		var document interface{}
+		err := decoder.Decode(&document)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if sequence, ok := document.([]interface{}); ok {
+			specs = append(specs, sequence...)
+		} else {
+			specs = append(specs, document)
+		}
+	}
+	return decodeBundle(normalizeSpecs(specs))
+}
+
+func parseTomlBundle(data []byte) ([]*BceCommand, error) {
+	var document map[string]interface{}
+	_, err := toml.Decode(string(data), &document)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []interface{}
+	if commands, ok := document["command"].([]map[string]interface{}); ok {
+		// a bundle, the other top-level keys (if any) can't be placed
+		for key := range document {
+			if key != "command" {
+				return nil, errors.New(key + ": is not a known attribute (expected command)")
+			}
+		}
+		for _, cmd := range commands {
+			specs = append(specs, map[string]interface{}{"command": cmd})
+		}
+	} else {
+		specs = append(specs, document)
+	}
+	return decodeBundle(normalizeSpecs(specs))
+}
+
+// normalizeSpecs converts the values decoded from YAML or TOML to those decoded from JSON, so they are checked alike
+func normalizeSpecs(specs []interface{}) []interface{} {
+	for i, spec := range specs {
+		specs[i] = normalizeSpecValue(spec)
+	}
+	return specs
+}
+
+func normalizeSpecValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, item := range v {
+			v[key] = normalizeSpecValue(item)
+		}
+		return v
+	case []interface{}:
+		for i, item := range v {
+			v[i] = normalizeSpecValue(item)
+		}
+		return v
+	case []map[string]interface{}:
+		var items []interface{}
+		for _, item := range v {
+			items = append(items, normalizeSpecValue(item))
+		}
+		return items
+	}
+	return value
+}
+
+// marshalSpecs writes a command as a spec, or the commands as a bundle
+func marshalSpecs(cmds []*BceCommand, format string, bundle bool) ([]byte, error) {
+	var wrappers = []BceCommandJsonWrapper{}
+	for _, cmd := range cmds {
+		wrappers = append(wrappers, BceCommandJsonWrapper{*cmd})
+	}
+
+	switch format {
+	case FormatJson:
+		if bundle {
+			return json.MarshalIndent(wrappers, "", "  ")
+		}
+		return json.MarshalIndent(wrappers[0], "", "  ")
+	case FormatYaml:
+		// a bundle is a stream of documents
+		var buffer bytes.Buffer
+		encoder := yaml.NewEncoder(&buffer)
+		encoder.SetIndent(2)
+		for _, wrapper := range wrappers {
+			err := encoder.Encode(wrapper)
+			if err != nil {
+				return nil, err
+			}
+		}
+		err := encoder.Close()
+		return buffer.Bytes(), err
+	case FormatToml:
+		var buffer bytes.Buffer
+		var err error
+		if bundle {
+			var commands []BceCommand
+			for _, wrapper := range wrappers {
+				commands = append(commands, wrapper.Command)
+			}
+			err = toml.NewEncoder(&buffer).Encode(struct {
+				Command []BceCommand `toml:"command"`
+			}{commands})
+		} else {
+			err = toml.NewEncoder(&buffer).Encode(wrappers[0])
+		}
+		return buffer.Bytes(), err
+	}
+	return nil, errors.New("unknown spec format: " + format + " (" + strings.Join(SpecFormats, ", ") + ")")
+}
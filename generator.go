@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+	"unicode"
+)
+
+// GeneratorTimeoutEnvVar overrides how long a generator may run (e.g. 500ms), before it is killed
+const GeneratorTimeoutEnvVar = "BCE_GENERATOR_TIMEOUT"
+
+const GeneratorDefaultTimeout = 2 * time.Second
+
+// GeneratorShell runs the generator command templates
+const GeneratorShell = "/bin/sh"
+
+// RunGenerator runs a generator command and recommends the lines of its output which match the current word.
+// Each line is a candidate, optionally followed by a tab and its description.
+// The command is run by the shell, with the context of the command line in its environment:
+//
+//	BCE_COMP_LINE        the command line
+//	BCE_CURRENT_WORD     the word being completed
+//	BCE_WORDS            the completed words, space separated
+//	BCE_FLAG_<NAME>      the value typed for an arg, e.g. BCE_FLAG_NAMESPACE for --namespace
+//	BCE_POSITIONAL_<N>   the value typed for the Nth positional
+//
+// so `kubectl get pods -n "${BCE_FLAG_NAMESPACE:-default}" -o name` completes the pods of the selected namespace.
+// The output is cached for ttl seconds (see runCachedGenerator).
+// A failing or slow generator only logs, completion carries on without its candidates.
+func RunGenerator(command string, ttl int, input *BashInput) []BceRecommendation {
+	output, err := runCachedGenerator(command, ttl, input.Dir, generatorEnv(input))
+	if err != nil {
+		log.Println("generator failed:", command, err)
+		return nil
+	}
+
+	var results []BceRecommendation
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		fields := strings.SplitN(line, "\t", 2)
+		name := strings.TrimSpace(fields[0])
+		var description string
+		if len(fields) > 1 {
+			description = strings.TrimSpace(fields[1])
+		}
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := input.MatchCurrentWord(name); ok {
+			results = append(results, BceRecommendation{Name: name, Description: description})
+		}
+	}
+	return results
+}
+
+// execGenerator runs the generator command in dir (if given), with env added to the environment
+func execGenerator(command string, dir string, env []string) ([]byte, error) {
+	if len(strings.TrimSpace(command)) == 0 {
+		return nil, errors.New("no generator command")
+	}
+
+	// the generator writes straight into the pipe, so waiting for it doesn't wait for a child still holding the pipe open
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	cmd := exec.Command(GeneratorShell, "-c", command)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Dir = dir
+	cmd.Stdout = writer
+	// its own process group, so it is killed along with its children
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	err = cmd.Start()
+	writer.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan []byte, 1)
+	go func() {
+		data, _ := ioutil.ReadAll(reader)
+		output <- data
+	}()
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	timeout := generatorTimeout()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	// killed on a timeout, the generator is reaped, and the reader stopped, before returning
+	kill := func() error {
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		reader.Close()
+		<-output
+		return fmt.Errorf("timed out after %v", timeout)
+	}
+
+	select {
+	case err = <-exited:
+	case <-timer.C:
+		err = kill()
+		<-exited
+		return nil, err
+	}
+	if err != nil {
+		// the children left in the group are of no use
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		return nil, err
+	}
+
+	// the output is complete once every child writing to it has finished too
+	select {
+	case data := <-output:
+		return data, nil
+	case <-timer.C:
+		return nil, kill()
+	}
+}
+
+func generatorTimeout() time.Duration {
+	value, ok := os.LookupEnv(GeneratorTimeoutEnvVar)
+	if !ok {
+		return GeneratorDefaultTimeout
+	}
+	timeout, err := time.ParseDuration(value)
+	if (err != nil) || (timeout <= 0) {
+		log.Println("invalid", GeneratorTimeoutEnvVar, "value:", value)
+		return GeneratorDefaultTimeout
+	}
+	return timeout
+}
+
+// generatorEnv builds the environment variables describing the command line, for the generator
+func generatorEnv(input *BashInput) []string {
+	var currentWord string
+	if input.CurrentWord != nil {
+		currentWord = *input.CurrentWord
+	}
+	env := []string{
+		"BCE_COMP_LINE=" + input.CmdLine,
+		"BCE_CURRENT_WORD=" + currentWord,
+		"BCE_WORDS=" + strings.Join(input.CompletedWords(), " "),
+	}
+	for name, value := range input.ArgValues {
+		env = append(env, "BCE_FLAG_"+generatorEnvName(name)+"="+value)
+	}
+	for i, value := range input.Positionals {
+		env = append(env, "BCE_POSITIONAL_"+strconv.Itoa(i+1)+"="+value)
+	}
+	return env
+}
+
+// generatorEnvName converts an arg name to an environment variable name, e.g. --dry-run to DRY_RUN
+func generatorEnvName(argName string) string {
+	name := strings.TrimLeft(argName, "-")
+	return strings.Map(func(c rune) rune {
+		if (c < unicode.MaxASCII) && (unicode.IsLetter(c) || unicode.IsDigit(c)) {
+			return unicode.ToUpper(c)
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,337 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// GeneratorCachePathEnvVar overrides the location of the generator cache
+const GeneratorCachePathEnvVar = "BCE_CACHE_DB"
+
+// GeneratorCacheFilename is the generator cache, in the user's cache directory ($XDG_CACHE_HOME/bce)
+const GeneratorCacheFilename = "generator_cache.db"
+
+// GeneratorCacheDefaultTTL is how long (in seconds) the output of a generator is fresh, when it has no TTL.
+// A negative TTL disables the cache for the generator.
+const GeneratorCacheDefaultTTL = 30
+
+// GeneratorCacheStaleTime is how long (in seconds) an expired output is still served, while it is refreshed in the background
+const GeneratorCacheStaleTime = 3600
+
+// GeneratorCacheMaxEntries caps the number of cached outputs, the least recently used are evicted
+const GeneratorCacheMaxEntries = 1000
+
+// GeneratorCacheMaxOutput caps the size of a cached output, larger outputs aren't cached
+const GeneratorCacheMaxOutput = 1024 * 1024
+
+// generator_cache holds the output of the generators, keyed by a hash of the generator and its context.
+// It is the only table of the generator cache database, the completion databases don't have it.
+const sqlCreateGeneratorCache = `
+	CREATE TABLE IF NOT EXISTS generator_cache (
+		key TEXT PRIMARY KEY,
+		generator TEXT NOT NULL,
+		output BLOB NOT NULL,
+		created_at INTEGER NOT NULL,
+		expires_at INTEGER NOT NULL,
+		accessed_at INTEGER NOT NULL
+	);
+	CREATE INDEX generator_cache_accessed_idx
+		ON generator_cache (accessed_at);
+`
+
+const sqlReadGeneratorCache = `
+	SELECT output, expires_at
+	FROM generator_cache
+	WHERE key = ?1
+`
+
+const sqlWriteGeneratorCache = `
+	INSERT OR REPLACE INTO generator_cache
+		(key, generator, output, created_at, expires_at, accessed_at)
+	VALUES
+		(?1, ?2, ?3, ?4, ?5, ?4)
+`
+
+const sqlTouchGeneratorCache = `
+	UPDATE generator_cache SET accessed_at = ?2 WHERE key = ?1
+`
+
+const sqlPostponeGeneratorCache = `
+	UPDATE generator_cache SET accessed_at = ?2, expires_at = ?3 WHERE key = ?1
+`
+
+const sqlEvictGeneratorCache = `
+	DELETE FROM generator_cache
+	WHERE (expires_at < ?1)
+		OR key NOT IN (SELECT key FROM generator_cache ORDER BY accessed_at DESC LIMIT ?2)
+`
+
+const sqlReadGeneratorCacheStats = `
+	SELECT generator, COUNT(*), COALESCE(SUM(LENGTH(output)), 0), COALESCE(SUM(expires_at >= ?1), 0)
+	FROM generator_cache
+	GROUP BY generator
+	ORDER BY generator
+`
+
+// generatorCacheConn is the generator cache, opened on its first use (by any of the daemon's requests)
+var generatorCacheConn *sql.DB
+var generatorCacheMutex sync.Mutex
+
+// GeneratorCachePath locates the generator cache: $BCE_CACHE_DB, then the user's cache directory
+func GeneratorCachePath() (string, error) {
+	if path := os.Getenv(GeneratorCachePathEnvVar); len(path) > 0 {
+		return path, nil
+	}
+	cacheHome, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(cacheHome, "bce", GeneratorCacheFilename), nil
+}
+
+// DBOpenGeneratorCache opens the generator cache, creating it if needed. It is a database of its own,
+// so running a generator never creates (nor seeds) the user's completion database.
+func DBOpenGeneratorCache() (*sql.DB, error) {
+	path, err := GeneratorCachePath()
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := DBOpen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaVersion, err := DBGetSchemaVersion(conn)
+	if (err == nil) && (schemaVersion == 0) {
+		_, err = conn.Exec(sqlCreateGeneratorCache + "PRAGMA user_version = 1;")
+	}
+	if err != nil {
+		DBClose(conn)
+		return nil, err
+	}
+	return conn, nil
+}
+
+func generatorCacheOpen() *sql.DB {
+	generatorCacheMutex.Lock()
+	defer generatorCacheMutex.Unlock()
+
+	if generatorCacheConn == nil {
+		conn, err := DBOpenGeneratorCache()
+		if err != nil {
+			log.Println("generator cache unavailable:", err)
+			return nil
+		}
+		// the daemon's requests run generators concurrently, a single connection takes their writes in turn
+		// (rather than failing them as busy)
+		conn.SetMaxOpenConns(1)
+		generatorCacheConn = conn
+	}
+	return generatorCacheConn
+}
+
+func GeneratorCacheClose() {
+	generatorCacheMutex.Lock()
+	defer generatorCacheMutex.Unlock()
+
+	if generatorCacheConn != nil {
+		DBClose(generatorCacheConn)
+		generatorCacheConn = nil
+	}
+}
+
+// generatorCacheKey identifies the output of a generator, run in dir (or the current directory) with the given context.
+// The context changes with every word typed (the current word on every key press), so a variable is only part
+// of the key if the generator refers to it.
+func generatorCacheKey(command string, dir string, env []string) string {
+	if len(dir) == 0 {
+		dir, _ = os.Getwd()
+	}
+	var context []string
+	for _, variable := range env {
+		name := strings.SplitN(variable, "=", 2)[0]
+		if strings.Contains(command, name) {
+			context = append(context, variable)
+		}
+	}
+	sort.Strings(context)
+
+	hash := sha256.New()
+	hash.Write([]byte(command + "\x00" + dir + "\x00" + strings.Join(context, "\x00")))
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// runCachedGenerator returns the cached output of the generator while it is fresh (for ttl seconds).
+// Once expired, the output is still returned for a while, and the generator is re-run in the background
+// (stale-while-revalidate), so a TAB press never waits on an expensive generator that has run before.
+func runCachedGenerator(command string, ttl int, dir string, env []string) ([]byte, error) {
+	if ttl < 0 {
+		return execGenerator(command, dir, env)
+	}
+	if ttl == 0 {
+		ttl = GeneratorCacheDefaultTTL
+	}
+	conn := generatorCacheOpen()
+	if conn == nil {
+		return execGenerator(command, dir, env)
+	}
+
+	key := generatorCacheKey(command, dir, env)
+	now := time.Now().Unix()
+
+	var output []byte
+	var expiresAt int64
+	err := conn.QueryRow(sqlReadGeneratorCache, key).Scan(&output, &expiresAt)
+	if err == nil {
+		if now <= expiresAt {
+			log.Println("generator cache hit:", command)
+			_, _ = conn.Exec(sqlTouchGeneratorCache, key, now)
+			return output, nil
+		}
+		if now-expiresAt <= GeneratorCacheStaleTime {
+			log.Println("generator cache stale:", command)
+			// postpone the expiry while the refresh runs, so other TAB presses don't start another one
+			refreshTime := int64(generatorTimeout()/time.Second) + 1
+			_, _ = conn.Exec(sqlPostponeGeneratorCache, key, now, now+refreshTime)
+			err = startGeneratorRefresh(command, ttl, dir, env)
+			if err != nil {
+				log.Println("generator refresh failed:", err)
+			}
+			return output, nil
+		}
+	} else if err != sql.ErrNoRows {
+		log.Println("generator cache read failed:", err)
+	}
+
+	log.Println("generator cache miss:", command)
+	output, err = execGenerator(command, dir, env)
+	if err != nil {
+		return nil, err
+	}
+	generatorCacheStore(conn, key, command, ttl, output)
+	return output, nil
+}
+
+func generatorCacheStore(conn *sql.DB, key string, command string, ttl int, output []byte) {
+	if len(output) > GeneratorCacheMaxOutput {
+		log.Println("generator output too large to cache:", command, len(output))
+		return
+	}
+	now := time.Now().Unix()
+	_, err := conn.Exec(sqlWriteGeneratorCache, key, command, output, now, now+int64(ttl))
+	if err == nil {
+		_, err = conn.Exec(sqlEvictGeneratorCache, now-GeneratorCacheStaleTime, GeneratorCacheMaxEntries)
+	}
+	if err != nil {
+		log.Println("generator cache write failed:", err)
+	}
+}
+
+// startGeneratorRefresh re-runs the generator in a separate bce process (bce --cache refresh TTL COMMAND ENV...),
+// run in dir, which isn't waited for. Its standard streams aren't inherited, so the shell doesn't wait for it either.
+func startGeneratorRefresh(command string, ttl int, dir string, env []string) error {
+	executable, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	args := append([]string{"--cache", "refresh", strconv.Itoa(ttl), command}, env...)
+
+	// without the shell's completion variables, as they would put bce in completion mode
+	cmd := exec.Command(executable, args...)
+	cmd.Dir = dir
+	for _, variable := range os.Environ() {
+		if !strings.HasPrefix(variable, BashLineVar+"=") && !strings.HasPrefix(variable, BashCursorVar+"=") {
+			cmd.Env = append(cmd.Env, variable)
+		}
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	return cmd.Process.Release()
+}
+
+// processCache handles the --cache actions: clear, stats (and refresh, used internally to revalidate a stale output)
+func processCache(action string, args []string) error {
+	conn, err := DBOpenGeneratorCache()
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	switch action {
+	case "clear":
+		result, err := conn.Exec("DELETE FROM generator_cache;")
+		if err != nil {
+			return err
+		}
+		count, _ := result.RowsAffected()
+		fmt.Println("Removed", count, "cached generator outputs")
+		return nil
+	case "stats":
+		return printGeneratorCacheStats(conn)
+	case "refresh":
+		if len(args) < 2 {
+			return errors.New("cache refresh requires a TTL and a generator")
+		}
+		ttl, err := strconv.Atoi(args[0])
+		if err != nil {
+			return err
+		}
+		command, env := args[1], args[2:]
+		output, err := execGenerator(command, "", env)
+		if err != nil {
+			return err
+		}
+		generatorCacheStore(conn, generatorCacheKey(command, "", env), command, ttl, output)
+		return nil
+	}
+	return errors.New("unknown cache action: " + action + " (clear, stats)")
+}
+
+func printGeneratorCacheStats(conn *sql.DB) error {
+	rows, err := conn.Query(sqlReadGeneratorCacheStats, time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var entries, size, fresh int64
+	for rows.Next() {
+		var generator string
+		var generatorEntries, generatorSize, generatorFresh int64
+		err := rows.Scan(&generator, &generatorEntries, &generatorSize, &generatorFresh)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s: %d entries (%d fresh), %d bytes\n", singleLine(generator), generatorEntries, generatorFresh, generatorSize)
+		entries += generatorEntries
+		size += generatorSize
+		fresh += generatorFresh
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("total: %d entries (%d fresh, %d stale), %d bytes, limit %d entries\n", entries, fresh, entries-fresh, size, GeneratorCacheMaxEntries)
+	return nil
+}
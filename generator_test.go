@@ -0,0 +1,221 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeStubGenerator writes a shell script standing in for a generator, returning the command to run it
+func writeStubGenerator(t *testing.T, script string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "generator.sh")
+	err := ioutil.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// the input for completing the command line, resolved against the command
+func generatorTestInput(cmd *BceCommand, cmdLine string) *BashInput {
+	input := NewCompletionInput(cmdLine, len(cmdLine))
+	parsed := cmd.ParseCommandLine(input.CompletedWords())
+	input.ArgValues = parsed.ArgValues
+	input.Positionals = parsed.Positionals
+	return input
+}
+
+func TestRunGeneratorParsesOutput(t *testing.T) {
+	command := writeStubGenerator(t, `printf 'web-1\tthe first web pod\r\n'
+printf '\n'
+printf 'web-2\n'
+printf '  db-1  \t  the database  \n'
+`)
+	cmd := &BceCommand{Name: "kubectl"}
+
+	results := RunGenerator(command, -1, generatorTestInput(cmd, "kubectl logs "))
+	expected := []BceRecommendation{
+		{Name: "web-1", Description: "the first web pod"},
+		{Name: "web-2"},
+		{Name: "db-1", Description: "the database"},
+	}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected %v, got %v", expected, results)
+	}
+
+	// only the candidates matching the current word
+	results = RunGenerator(command, -1, generatorTestInput(cmd, "kubectl logs we"))
+	if (len(results) != 2) || (results[0].Name != "web-1") || (results[1].Name != "web-2") {
+		t.Errorf("expected web-1 and web-2, got %v", results)
+	}
+}
+
+func TestRunGeneratorEnvironment(t *testing.T) {
+	command := writeStubGenerator(t, `echo "line=$BCE_COMP_LINE"
+echo "word=$BCE_CURRENT_WORD"
+echo "words=$BCE_WORDS"
+echo "namespace=$BCE_FLAG_NAMESPACE"
+echo "n=$BCE_FLAG_N"
+echo "dry_run=$BCE_FLAG_DRY_RUN"
+echo "positional1=$BCE_POSITIONAL_1"
+echo "positional2=$BCE_POSITIONAL_2"
+`)
+	cmd := &BceCommand{
+		Name: "kubectl",
+		Args: []BceCommandArg{
+			{LongName: "--namespace", ShortName: "-n", ArgType: ArgTypeText},
+			{LongName: "--dry-run", ArgType: ArgTypeOption},
+		},
+		SubCommands: []BceCommand{{Name: "logs"}},
+	}
+
+	var names []string
+	for _, result := range RunGenerator(command, -1, generatorTestInput(cmd, "kubectl logs -n prod --dry-run server web-1 ")) {
+		names = append(names, result.Name)
+	}
+	expected := []string{
+		"line=kubectl logs -n prod --dry-run server web-1", // the candidates are trimmed
+		"word=",
+		"words=kubectl logs -n prod --dry-run server web-1",
+		"namespace=prod",
+		"n=prod",
+		"dry_run=server",
+		"positional1=web-1",
+		"positional2=",
+	}
+	if !reflect.DeepEqual(names, expected) {
+		t.Errorf("expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(names, "\n"))
+	}
+}
+
+func TestExecGeneratorTimeout(t *testing.T) {
+	t.Setenv(GeneratorTimeoutEnvVar, "300ms")
+
+	start := time.Now()
+	_, err := execGenerator(writeStubGenerator(t, "sleep 30\n"), "", nil)
+	if err == nil {
+		t.Error("a slow generator didn't time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the timeout took %v", elapsed)
+	}
+}
+
+// a child left running in the background, holding the output open, is killed with the generator
+func TestExecGeneratorTimeoutKillsChildren(t *testing.T) {
+	t.Setenv(GeneratorTimeoutEnvVar, "300ms")
+	pidFile := filepath.Join(t.TempDir(), "child.pid")
+
+	start := time.Now()
+	_, err := execGenerator(writeStubGenerator(t, "sleep 30 &\necho $! > "+pidFile+"\necho early\n"), "", nil)
+	if err == nil {
+		t.Error("a generator whose child holds the output open didn't time out")
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("the timeout took %v", elapsed)
+	}
+
+	data, err := ioutil.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// gone, or a zombie waiting for init to reap it
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		stat, err := ioutil.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+		if os.IsNotExist(err) || ((err == nil) && strings.Contains(string(stat), ") Z ")) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("the generator's child %d is still running", pid)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestExecGeneratorFailure(t *testing.T) {
+	_, err := execGenerator(writeStubGenerator(t, "echo partial\nexit 3\n"), "", nil)
+	if err == nil {
+		t.Error("a failing generator succeeded")
+	}
+}
+
+// the generator cache is a database of its own, running a generator doesn't create the user's database
+func TestRunGeneratorCacheFile(t *testing.T) {
+	dir := t.TempDir()
+	userPath := filepath.Join(dir, "user.db")
+	cachePath := filepath.Join(dir, "cache", GeneratorCacheFilename)
+	t.Setenv(DBPathEnvVar, userPath)
+	t.Setenv(GeneratorCachePathEnvVar, cachePath)
+	defer GeneratorCacheClose()
+
+	command := writeStubGenerator(t, "echo web-1\n")
+	results := RunGenerator(command, 60, generatorTestInput(&BceCommand{Name: "kubectl"}, "kubectl logs "))
+	if (len(results) != 1) || (results[0].Name != "web-1") {
+		t.Errorf("expected web-1, got %v", results)
+	}
+	if fileExists(userPath) {
+		t.Error("the user database was created")
+	}
+
+	var count int
+	err := generatorCacheOpen().QueryRow("SELECT COUNT(*) FROM generator_cache;").Scan(&count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected the output cached in %s, found %d entries", cachePath, count)
+	}
+}
+
+// only the context variables the generator refers to are part of its cache key
+func TestRunGeneratorCacheKey(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+	t.Setenv(GeneratorCachePathEnvVar, filepath.Join(dir, "cache.db"))
+	defer GeneratorCacheClose()
+
+	runsPath := filepath.Join(dir, "runs")
+	command := writeStubGenerator(t, "echo run >> "+runsPath+"\necho \"pod-$1\"\n") + ` "$BCE_FLAG_NAMESPACE"`
+	cmd := &BceCommand{
+		Name: "kubectl",
+		Args: []BceCommandArg{
+			{LongName: "--namespace", ShortName: "-n", ArgType: ArgTypeText},
+			{LongName: "--output", ArgType: ArgTypeText},
+		},
+		SubCommands: []BceCommand{{Name: "logs"}},
+	}
+
+	for _, test := range []struct {
+		cmdLine  string
+		expected string
+		runs     int
+	}{
+		{"kubectl logs -n dev ", "pod-dev", 1},
+		// an unrelated flag, another positional and the current word
+		{"kubectl logs -n dev --output json web po", "pod-dev", 1},
+		{"kubectl logs -n prod ", "pod-prod", 2},
+	} {
+		results := RunGenerator(command, 60, generatorTestInput(cmd, test.cmdLine))
+		if (len(results) != 1) || (results[0].Name != test.expected) {
+			t.Errorf("%q: expected %s, got %v", test.cmdLine, test.expected, results)
+		}
+		data, err := ioutil.ReadFile(runsPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if runs := strings.Count(string(data), "run"); runs != test.runs {
+			t.Errorf("%q: the generator ran %d times, expected %d", test.cmdLine, runs, test.runs)
+		}
+	}
+}
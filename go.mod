@@ -3,6 +3,8 @@ module bce
 go 1.17
 
 require (
-	github.com/google/uuid v1.3.0 // indirect
-	github.com/mattn/go-sqlite3 v1.14.12 // indirect
+	github.com/BurntSushi/toml v1.3.2
+	github.com/google/uuid v1.3.0
+	github.com/mattn/go-sqlite3 v1.14.12
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,636 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// The index is a read-only compilation of a layer's database, for completion. It is laid out as fixed-size records
+// (of little-endian uint32 words) which refer to a table of strings, so it is searched in place, without being decoded
+// (it is read whole, but the layout would allow memory-mapping it). The sub-commands, aliases, args and opts of
+// a command are contiguous and sorted by name, so they can be binary (and prefix) searched.
+//
+// header:   magic (8 bytes), format version, body length, body CRC-32, and the database it was compiled from:
+// its user_version, then its size, mtime, WAL size and WAL mtime (64 bits each)
+// body:     section table (offset and count of each section), then the sections
+const IndexMagic = "BCEINDEX"
+
+const IndexFormatVersion = 2
+
+// IndexFileSuffix is appended to a layer's database path, to locate its index
+const IndexFileSuffix = ".idx"
+
+// ExportAllCommands is the --export value selecting every command (e.g. for the index)
+const ExportAllCommands = "all"
+
+const indexHeaderSize = 56
+
+// index sections
+const (
+	indexStrings = iota
+	indexCommands
+	indexRoots
+	indexAliases
+	indexArgs
+	indexOpts
+	indexPositionals
+	indexPositionalOpts
+	indexTombstones
+	indexSectionCount
+)
+
+// record sizes (in words) of the sections, strings are a pair of words (offset, length) into the strings section
+var indexRecordWords = [indexSectionCount]int{
+	indexStrings:        0,
+	indexCommands:       13, // uuid, name, parent, first sub-command, sub-commands, first alias, aliases, first arg, args, first positional, positionals
+	indexRoots:          3,  // name (or alias), command
+	indexAliases:        2,  // name
+	indexArgs:           17, // uuid, type, description, long name, short name, file filter, generator, generator TTL, first opt, opts
+	indexOpts:           2,  // name
+	indexPositionals:    18, // uuid, position, name, arity, type, description, file filter, generator, generator TTL, first opt, opts
+	indexPositionalOpts: 2,  // name
+	indexTombstones:     2,  // name
+}
+
+const indexNone = ^uint32(0)
+
+// BceIndex is a validated index
+type BceIndex struct {
+	data     []byte
+	sections [indexSectionCount]struct{ offset, count uint32 }
+}
+
+// bceIndexSource is the state of the database an index was compiled from. The index is stale once the database
+// has changed (even by another program than bce), and completion then falls back to the database.
+type bceIndexSource struct {
+	schemaVersion uint32
+	size          int64
+	modTime       int64
+	walSize       int64
+	walModTime    int64
+}
+
+// indexSourceOf reads the state of a database from its file, and its WAL file
+func indexSourceOf(dbPath string) (bceIndexSource, error) {
+	var source bceIndexSource
+	file, err := os.Open(dbPath)
+	if err != nil {
+		return source, err
+	}
+	defer file.Close()
+
+	// the user_version is a big-endian word at offset 60 of the SQLite file header
+	header := make([]byte, 100)
+	_, err = io.ReadFull(file, header)
+	if err != nil {
+		return source, err
+	}
+	source.schemaVersion = binary.BigEndian.Uint32(header[60:])
+	info, err := file.Stat()
+	if err != nil {
+		return source, err
+	}
+	source.size, source.modTime = info.Size(), info.ModTime().UnixNano()
+
+	// an empty WAL is the same as none, it is deleted when the last connection closes
+	info, err = os.Stat(dbPath + "-wal")
+	if (err == nil) && (info.Size() > 0) {
+		source.walSize, source.walModTime = info.Size(), info.ModTime().UnixNano()
+	} else if (err != nil) && !os.IsNotExist(err) {
+		return source, err
+	}
+	return source, nil
+}
+
+// DBIndexPath locates the index of a layer, next to its database
+func DBIndexPath(layer string) (string, error) {
+	path, err := DBLayerPath(layer)
+	if err != nil {
+		return "", err
+	}
+	return path + IndexFileSuffix, nil
+}
+
+// processExportIndex compiles a layer's database (the user's by default) into its index, or filename if given
+func processExportIndex(commandName string, filename string, layer string) error {
+	if commandName != ExportAllCommands {
+		return errors.New("the index holds every command, export it with --export " + ExportAllCommands)
+	}
+	if len(layer) == 0 {
+		layer = DBLayerUser
+	}
+	if len(filename) == 0 {
+		path, err := DBIndexPath(layer)
+		if err != nil {
+			return err
+		}
+		filename = path
+	}
+
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	return DBCompileIndex(conn, filename)
+}
+
+// DBIndexRefresh recompiles a layer's index after its database has changed, if the layer has an index
+func DBIndexRefresh(layer string) error {
+	path, err := DBIndexPath(layer)
+	if err != nil {
+		return err
+	}
+	if !fileExists(path) {
+		return nil
+	}
+
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	return DBCompileIndex(conn, path)
+}
+
+// bceIndexWriter accumulates the sections of an index
+type bceIndexWriter struct {
+	strings     bytes.Buffer
+	stringIndex map[string]uint32
+	sections    [indexSectionCount][]uint32
+}
+
+func (w *bceIndexWriter) str(value string) []uint32 {
+	offset, ok := w.stringIndex[value]
+	if !ok {
+		offset = uint32(w.strings.Len())
+		w.strings.WriteString(value)
+		w.stringIndex[value] = offset
+	}
+	return []uint32{offset, uint32(len(value))}
+}
+
+func (w *bceIndexWriter) count(section int) uint32 {
+	return uint32(len(w.sections[section]) / indexRecordWords[section])
+}
+
+func (w *bceIndexWriter) add(section int, words ...[]uint32) {
+	for _, word := range words {
+		w.sections[section] = append(w.sections[section], word...)
+	}
+}
+
+func one(value uint32) []uint32 {
+	return []uint32{value}
+}
+
+// DBCompileIndex writes the index of a database, replacing the file atomically
+func DBCompileIndex(conn *sql.DB, filename string) error {
+	dbPath, err := DBFilePath(conn)
+	if err != nil {
+		return err
+	}
+	if len(dbPath) == 0 {
+		return errors.New("an index needs a database file")
+	}
+	// move the WAL into the database, so the database file is the state the index is compiled from
+	_, err = conn.Exec("PRAGMA wal_checkpoint(TRUNCATE);")
+	if err != nil {
+		return err
+	}
+
+	names, err := DBQueryRootCommandNames(conn)
+	if err != nil {
+		return err
+	}
+	tombstones, err := DBQueryCommandTombstones(conn)
+	if err != nil {
+		return err
+	}
+
+	var roots []*BceCommand
+	for _, name := range names {
+		cmd, err := DBQueryCommand(conn, name)
+		if err != nil {
+			return err
+		}
+		if cmd != nil {
+			roots = append(roots, cmd)
+		}
+	}
+
+	w := &bceIndexWriter{stringIndex: map[string]uint32{}}
+
+	// the commands are numbered breadth first, so the sub-commands of each command are contiguous
+	type queued struct {
+		cmd    *BceCommand
+		parent uint32
+	}
+	var queue []queued
+	for _, root := range roots {
+		queue = append(queue, queued{root, indexNone})
+	}
+	type rootName struct {
+		name string
+		cmd  uint32
+	}
+	var rootNames []rootName
+	for i := 0; i < len(queue); i++ {
+		cmd, parent := queue[i].cmd, queue[i].parent
+		if parent == indexNone {
+			rootNames = append(rootNames, rootName{cmd.Name, uint32(i)})
+			for _, alias := range cmd.Aliases {
+				rootNames = append(rootNames, rootName{alias.Name, uint32(i)})
+			}
+		}
+
+		subCmds := append([]BceCommand(nil), cmd.SubCommands...)
+		sort.Slice(subCmds, func(a, b int) bool { return subCmds[a].Name < subCmds[b].Name })
+		firstSubCmd := uint32(len(queue))
+		for j := range subCmds {
+			queue = append(queue, queued{&subCmds[j], uint32(i)})
+		}
+
+		aliases := append([]BceCommandAlias(nil), cmd.Aliases...)
+		sort.Slice(aliases, func(a, b int) bool { return aliases[a].Name < aliases[b].Name })
+		firstAlias := w.count(indexAliases)
+		for _, alias := range aliases {
+			w.add(indexAliases, w.str(alias.Name))
+		}
+
+		firstArg := w.count(indexArgs)
+		for _, arg := range cmd.Args {
+			firstOpt := w.count(indexOpts)
+			for _, opt := range sortedOptNames(arg.Opts) {
+				w.add(indexOpts, w.str(opt))
+			}
+			w.add(indexArgs, w.str(arg.Uuid), w.str(arg.ArgType), w.str(arg.Description), w.str(arg.LongName), w.str(arg.ShortName),
+				w.str(arg.FileFilter), w.str(arg.Generator), one(uint32(int32(arg.GeneratorTTL))), one(firstOpt), one(uint32(len(arg.Opts))))
+		}
+
+		firstPositional := w.count(indexPositionals)
+		for _, positional := range cmd.Positionals {
+			firstOpt := w.count(indexPositionalOpts)
+			for _, opt := range sortedOptNames(positional.Opts) {
+				w.add(indexPositionalOpts, w.str(opt))
+			}
+			w.add(indexPositionals, w.str(positional.Uuid), one(uint32(positional.Position)), w.str(positional.Name), w.str(positional.Arity),
+				w.str(positional.ArgType), w.str(positional.Description), w.str(positional.FileFilter), w.str(positional.Generator),
+				one(uint32(int32(positional.GeneratorTTL))), one(firstOpt), one(uint32(len(positional.Opts))))
+		}
+
+		w.add(indexCommands, w.str(cmd.Uuid), w.str(cmd.Name), one(parent), one(firstSubCmd), one(uint32(len(subCmds))),
+			one(firstAlias), one(uint32(len(aliases))), one(firstArg), one(uint32(len(cmd.Args))),
+			one(firstPositional), one(uint32(len(cmd.Positionals))))
+	}
+
+	sort.Slice(rootNames, func(a, b int) bool { return rootNames[a].name < rootNames[b].name })
+	for _, root := range rootNames {
+		w.add(indexRoots, w.str(root.name), one(root.cmd))
+	}
+	sort.Strings(tombstones)
+	for _, tombstone := range tombstones {
+		w.add(indexTombstones, w.str(tombstone))
+	}
+
+	source, err := indexSourceOf(dbPath)
+	if err != nil {
+		return err
+	}
+
+	// readers never see a partial index
+	err = ioutil.WriteFile(filename+".tmp", w.bytes(source), 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(filename+".tmp", filename)
+}
+
+func sortedOptNames(opts []BceCommandOpt) []string {
+	var names []string
+	for _, opt := range opts {
+		names = append(names, opt.Name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func appendUint32(data []byte, value uint32) []byte {
+	var word [4]byte
+	binary.LittleEndian.PutUint32(word[:], value)
+	return append(data, word[:]...)
+}
+
+func appendInt64(data []byte, value int64) []byte {
+	var word [8]byte
+	binary.LittleEndian.PutUint64(word[:], uint64(value))
+	return append(data, word[:]...)
+}
+
+// bytes lays out the header, section table and sections
+func (w *bceIndexWriter) bytes(source bceIndexSource) []byte {
+	var body []byte
+	offset := uint32(indexSectionCount * 8)
+	var table, sections []byte
+	for section := 0; section < indexSectionCount; section++ {
+		var data []byte
+		var count uint32
+		if section == indexStrings {
+			data = w.strings.Bytes()
+			count = uint32(len(data))
+		} else {
+			data = make([]byte, 4*len(w.sections[section]))
+			for i, word := range w.sections[section] {
+				binary.LittleEndian.PutUint32(data[4*i:], word)
+			}
+			count = w.count(section)
+		}
+		// keep the records word-aligned
+		for len(data)%4 != 0 {
+			data = append(data, 0)
+		}
+		table = appendUint32(table, offset)
+		table = appendUint32(table, count)
+		sections = append(sections, data...)
+		offset += uint32(len(data))
+	}
+	body = append(table, sections...)
+
+	header := []byte(IndexMagic)
+	header = appendUint32(header, IndexFormatVersion)
+	header = appendUint32(header, uint32(len(body)))
+	header = appendUint32(header, crc32.ChecksumIEEE(body))
+	header = appendUint32(header, source.schemaVersion)
+	header = appendInt64(header, source.size)
+	header = appendInt64(header, source.modTime)
+	header = appendInt64(header, source.walSize)
+	header = appendInt64(header, source.walModTime)
+	return append(header, body...)
+}
+
+// DBOpenIndex reads and validates the index of a database, returning nil if there isn't one,
+// or if it is stale (the database changed since it was compiled)
+func DBOpenIndex(filename string, dbPath string) (*BceIndex, error) {
+	data, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if (len(data) < indexHeaderSize) || (string(data[:len(IndexMagic)]) != IndexMagic) {
+		return nil, errors.New(filename + ": not a bce index")
+	}
+	version := binary.LittleEndian.Uint32(data[8:])
+	if version != IndexFormatVersion {
+		return nil, errors.New(filename + ": unsupported index version, re-export the index")
+	}
+	length := binary.LittleEndian.Uint32(data[12:])
+	body := data[indexHeaderSize:]
+	if (uint32(len(body)) != length) || (crc32.ChecksumIEEE(body) != binary.LittleEndian.Uint32(data[16:])) {
+		return nil, errors.New(filename + ": corrupt index (checksum mismatch), re-export the index")
+	}
+	if len(body) < indexSectionCount*8 {
+		return nil, errors.New(filename + ": corrupt index (truncated)")
+	}
+
+	source, err := indexSourceOf(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	compiled := bceIndexSource{
+		schemaVersion: binary.LittleEndian.Uint32(data[20:]),
+		size:          int64(binary.LittleEndian.Uint64(data[24:])),
+		modTime:       int64(binary.LittleEndian.Uint64(data[32:])),
+		walSize:       int64(binary.LittleEndian.Uint64(data[40:])),
+		walModTime:    int64(binary.LittleEndian.Uint64(data[48:])),
+	}
+	if source != compiled {
+		log.Println("stale index:", filename)
+		return nil, nil
+	}
+
+	index := &BceIndex{data: body}
+	for section := 0; section < indexSectionCount; section++ {
+		index.sections[section].offset = binary.LittleEndian.Uint32(body[8*section:])
+		index.sections[section].count = binary.LittleEndian.Uint32(body[8*section+4:])
+		size := uint64(index.sections[section].count) * uint64(4*indexRecordWords[section])
+		if section == indexStrings {
+			size = uint64(index.sections[section].count)
+		}
+		if uint64(index.sections[section].offset)+size > uint64(len(body)) {
+			return nil, errors.New(filename + ": corrupt index (bad section)")
+		}
+	}
+	return index, nil
+}
+
+// word reads a word of a record
+func (index *BceIndex) word(section int, record uint32, word int) uint32 {
+	offset := index.sections[section].offset + 4*(record*uint32(indexRecordWords[section])+uint32(word))
+	return binary.LittleEndian.Uint32(index.data[offset:])
+}
+
+// str reads a string of a record, from its (offset, length) words
+func (index *BceIndex) str(section int, record uint32, word int) string {
+	offset := index.word(section, record, word)
+	length := index.word(section, record, word+1)
+	start := uint64(index.sections[indexStrings].offset) + uint64(offset)
+	if (uint64(offset)+uint64(length) > uint64(index.sections[indexStrings].count)) || (start+uint64(length) > uint64(len(index.data))) {
+		return ""
+	}
+	return string(index.data[start : start+uint64(length)])
+}
+
+// search binary searches the records [first, first+count), which are sorted by the string at word,
+// returning the first record whose string isn't less than name
+func (index *BceIndex) search(section int, word int, first uint32, count uint32, name string) uint32 {
+	i := sort.Search(int(count), func(i int) bool {
+		return index.str(section, first+uint32(i), word) >= name
+	})
+	return first + uint32(i)
+}
+
+// SearchRootNames returns the root command names and aliases starting with prefix
+func (index *BceIndex) SearchRootNames(prefix string) []string {
+	var names []string
+	count := index.sections[indexRoots].count
+	for i := index.search(indexRoots, 0, 0, count, prefix); i < count; i++ {
+		name := index.str(indexRoots, i, 0)
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// rootCommand finds a root command by name or alias
+func (index *BceIndex) rootCommand(name string) (uint32, bool) {
+	count := index.sections[indexRoots].count
+	i := index.search(indexRoots, 0, 0, count, name)
+	if (i < count) && (index.str(indexRoots, i, 0) == name) {
+		return index.word(indexRoots, i, 2), true
+	}
+	return 0, false
+}
+
+// shadowedNames lists the names of the root commands, and the tombstones, which shadow the lower layers
+func (index *BceIndex) shadowedNames() []string {
+	var names []string
+	// the root commands come first
+	for i := uint32(0); (i < index.sections[indexCommands].count) && (index.word(indexCommands, i, 4) == indexNone); i++ {
+		names = append(names, index.str(indexCommands, i, 2))
+	}
+	for i := uint32(0); i < index.sections[indexTombstones].count; i++ {
+		names = append(names, index.str(indexTombstones, i, 0))
+	}
+	return names
+}
+
+// subCommand finds a sub-command of a command by name, then by alias
+func (index *BceIndex) subCommand(cmd uint32, name string) (uint32, bool) {
+	first, count := index.word(indexCommands, cmd, 5), index.word(indexCommands, cmd, 6)
+	i := index.search(indexCommands, 2, first, count, name)
+	if (i < first+count) && (index.str(indexCommands, i, 2) == name) {
+		return i, true
+	}
+	for i := first; i < first+count; i++ {
+		aliasFirst, aliasCount := index.word(indexCommands, i, 7), index.word(indexCommands, i, 8)
+		j := index.search(indexAliases, 0, aliasFirst, aliasCount, name)
+		if (j < aliasFirst+aliasCount) && (index.str(indexAliases, j, 0) == name) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// command decodes a command, with its aliases, args and positionals (but not its sub-commands)
+func (index *BceIndex) command(cmd uint32) BceCommand {
+	var result = BceCommand{Uuid: index.str(indexCommands, cmd, 0), Name: index.str(indexCommands, cmd, 2)}
+	if parent := index.word(indexCommands, cmd, 4); parent != indexNone {
+		parentUuid := index.str(indexCommands, parent, 0)
+		result.ParentCmdUuid = &parentUuid
+	}
+
+	first, count := index.word(indexCommands, cmd, 7), index.word(indexCommands, cmd, 8)
+	for i := first; i < first+count; i++ {
+		result.Aliases = append(result.Aliases, BceCommandAlias{CmdUuid: result.Uuid, Name: index.str(indexAliases, i, 0)})
+	}
+
+	first, count = index.word(indexCommands, cmd, 9), index.word(indexCommands, cmd, 10)
+	for i := first; i < first+count; i++ {
+		arg := BceCommandArg{Uuid: index.str(indexArgs, i, 0), CmdUuid: result.Uuid, ArgType: index.str(indexArgs, i, 2),
+			Description: index.str(indexArgs, i, 4), LongName: index.str(indexArgs, i, 6), ShortName: index.str(indexArgs, i, 8),
+			FileFilter: index.str(indexArgs, i, 10), Generator: index.str(indexArgs, i, 12), GeneratorTTL: int(int32(index.word(indexArgs, i, 14)))}
+		optFirst, optCount := index.word(indexArgs, i, 15), index.word(indexArgs, i, 16)
+		for j := optFirst; j < optFirst+optCount; j++ {
+			arg.Opts = append(arg.Opts, BceCommandOpt{ArgUuid: arg.Uuid, Name: index.str(indexOpts, j, 0)})
+		}
+		result.Args = append(result.Args, arg)
+	}
+
+	first, count = index.word(indexCommands, cmd, 11), index.word(indexCommands, cmd, 12)
+	for i := first; i < first+count; i++ {
+		positional := BceCommandPositional{Uuid: index.str(indexPositionals, i, 0), CmdUuid: result.Uuid,
+			Position: int(index.word(indexPositionals, i, 2)), Name: index.str(indexPositionals, i, 3), Arity: index.str(indexPositionals, i, 5),
+			ArgType: index.str(indexPositionals, i, 7), Description: index.str(indexPositionals, i, 9), FileFilter: index.str(indexPositionals, i, 11),
+			Generator: index.str(indexPositionals, i, 13), GeneratorTTL: int(int32(index.word(indexPositionals, i, 15)))}
+		optFirst, optCount := index.word(indexPositionals, i, 16), index.word(indexPositionals, i, 17)
+		for j := optFirst; j < optFirst+optCount; j++ {
+			positional.Opts = append(positional.Opts, BceCommandOpt{ArgUuid: positional.Uuid, Name: index.str(indexPositionalOpts, j, 0)})
+		}
+		result.Positionals = append(result.Positionals, positional)
+	}
+	return result
+}
+
+// QueryCommandPath is DBQueryCommandPath, reading the index rather than the database
+func (index *BceIndex) QueryCommandPath(cmdName string, words []string) *BceCommand {
+	root, ok := index.rootCommand(cmdName)
+	if !ok {
+		return nil
+	}
+	var positions = map[*BceCommand]uint32{}
+	cmd := index.command(root)
+	positions[&cmd] = root
+
+	cmdLine, _ := cmd.parseCommandLine(words, func(current *BceCommand, word string) (*BceCommand, error) {
+		i, ok := index.subCommand(positions[current], word)
+		if !ok {
+			return nil, nil
+		}
+		// the siblings of a typed sub-command aren't needed
+		current.SubCommands = []BceCommand{index.command(i)}
+		positions[&current.SubCommands[0]] = i
+		return &current.SubCommands[0], nil
+	})
+
+	// the sub-commands which may be typed next, with their aliases only
+	last := cmdLine.Path[len(cmdLine.Path)-1]
+	first, count := index.word(indexCommands, positions[last], 5), index.word(indexCommands, positions[last], 6)
+	last.SubCommands = nil
+	for i := first; i < first+count; i++ {
+		subCmd := BceCommand{Uuid: index.str(indexCommands, i, 0), Name: index.str(indexCommands, i, 2), ParentCmdUuid: &last.Uuid}
+		aliasFirst, aliasCount := index.word(indexCommands, i, 7), index.word(indexCommands, i, 8)
+		for j := aliasFirst; j < aliasFirst+aliasCount; j++ {
+			subCmd.Aliases = append(subCmd.Aliases, BceCommandAlias{CmdUuid: subCmd.Uuid, Name: index.str(indexAliases, j, 0)})
+		}
+		last.SubCommands = append(last.SubCommands, subCmd)
+	}
+	return &cmd
+}
+
+// IndexQueryCommandPathLayered is DBQueryCommandPathLayered, reading the indexes of the layers.
+// It is only usable (ok) when every layer with a database has a valid index.
+func IndexQueryCommandPathLayered(cmdName string, words []string) (cmd *BceCommand, ok bool, err error) {
+	var indexes []*BceIndex
+	for _, layer := range DBLayers {
+		path, err := DBLayerPath(layer)
+		if err != nil {
+			return nil, false, err
+		}
+		if !fileExists(path) {
+			continue
+		}
+		index, err := DBOpenIndex(path+IndexFileSuffix, path)
+		if err != nil {
+			return nil, false, err
+		}
+		if index == nil {
+			return nil, false, nil
+		}
+		indexes = append(indexes, index)
+	}
+	if len(indexes) == 0 {
+		return nil, false, nil
+	}
+
+	// the root command names (and tombstones) of the layers above the current one
+	var shadowed []string
+	for _, index := range indexes {
+		if root, found := index.rootCommand(cmdName); found {
+			if contains(shadowed, index.str(indexCommands, root, 2)) {
+				return nil, true, nil
+			}
+			return index.QueryCommandPath(cmdName, words), true, nil
+		}
+		shadowed = append(shadowed, index.shadowedNames()...)
+		if contains(shadowed, cmdName) {
+			return nil, true, nil
+		}
+	}
+	return nil, true, nil
+}
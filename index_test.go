@@ -0,0 +1,61 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// indexTestImport imports a command into the user database, closing it as the bce command would
+func indexTestImport(t *testing.T, cmd *BceCommand) {
+	t.Helper()
+	conn, err := DBOpenLayer(DBLayerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DBImportCommands(conn, []*BceCommand{cmd}, BceImportOptions{})
+	DBClose(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// an index is only used while its database hasn't changed, otherwise completion falls back to the database
+func TestIndexStale(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+	t.Setenv(DBSystemPathEnvVar, filepath.Join(dir, "system.db"))
+
+	indexTestImport(t, &BceCommand{Uuid: "00000000-0000-0000-0000-0000000c0001", Name: "alpha"})
+	err := processExportIndex(ExportAllCommands, "", DBLayerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, ok, err := IndexQueryCommandPathLayered("alpha", []string{"alpha"})
+	if (err != nil) || !ok || (cmd == nil) {
+		t.Fatalf("the index wasn't used: %v %v %v", cmd, ok, err)
+	}
+
+	// changed after the index was compiled, while another connection keeps the change in the WAL
+	reader, err := DBOpenLayer(DBLayerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DBClose(reader)
+	indexTestImport(t, &BceCommand{Uuid: "00000000-0000-0000-0000-0000000c0002", Name: "beta"})
+	_, ok, err = IndexQueryCommandPathLayered("beta", []string{"beta"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok {
+		t.Error("the stale index was used")
+	}
+
+	err = DBIndexRefresh(DBLayerUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, ok, err = IndexQueryCommandPathLayered("beta", []string{"beta"})
+	if (err != nil) || !ok || (cmd == nil) {
+		t.Errorf("the refreshed index wasn't used: %v %v %v", cmd, ok, err)
+	}
+}
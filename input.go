@@ -4,19 +4,30 @@ import (
 	"errors"
 	"os"
 	"strconv"
+	"strings"
 	"unicode"
+	"unicode/utf8"
 )
 
 const BashLineVar = "COMP_LINE"
 const BashCursorVar = "COMP_POINT"
 const BashMaxLineSize = 4096
 
+// IgnoreCaseEnvVar enables case-insensitive matching of the current word
+const IgnoreCaseEnvVar = "BCE_IGNORE_CASE"
+
 type BashInput struct {
 	CursorPosition int
 	CmdLine        string
 	CmdName        *string
 	CurrentWord    *string
 	PreviousWord   *string
+	IgnoreCase     bool
+	// Dir is the working directory of the shell, where files are completed and generators are run
+	Dir string
+	// ArgValues and Positionals are the values typed so far (resolved against the command), for the generators
+	ArgValues   map[string]string
+	Positionals []string
 }
 
 type BashParseState uint8
@@ -41,11 +52,24 @@ func CreateCompletionInput() (*BashInput, error) {
 	if err != nil {
 		return nil, err
 	}
+	return NewCompletionInput(cmdLine, cursorPos), nil
+}
+
+// NewCompletionInput builds the input from a command line and cursor position supplied by any shell frontend
+func NewCompletionInput(cmdLine string, cursorPos int) *BashInput {
+	if (cursorPos < 0) || (cursorPos > len(cmdLine)) {
+		cursorPos = len(cmdLine)
+	}
+	ignoreCase, err := strconv.ParseBool(os.Getenv(IgnoreCaseEnvVar))
+	if err != nil {
+		ignoreCase = false
+	}
 	commandName := getCommandNameFromInput(cmdLine)
 	currentWord := getCurrentWord(cmdLine, cursorPos)
 	previousWord := getPreviousWord(cmdLine, cursorPos)
-	input := BashInput{cursorPos, cmdLine, commandName, currentWord, previousWord}
-	return &input, nil
+	input := BashInput{CursorPosition: cursorPos, CmdLine: cmdLine, CmdName: commandName, CurrentWord: currentWord, PreviousWord: previousWord, IgnoreCase: ignoreCase}
+	input.Dir, _ = os.Getwd()
+	return &input
 }
 
 func getCommandNameFromInput(cmdLine string) *string {
@@ -57,17 +81,24 @@ func getCommandNameFromInput(cmdLine string) *string {
 	}
 }
 
+// getCurrentWord returns the (possibly partial) word at the cursor, which is empty when the cursor follows a word break
 func getCurrentWord(cmdLine string, cursorPosition int) *string {
-	list := BashInputToList(cmdLine, cursorPosition)
-	if len(list) >= 1 {
-		return &list[len(list)-1]
-	} else {
-		return nil
+	var word string
+	if !isWordBreak(cmdLine, cursorPosition) {
+		list := BashInputToList(cmdLine, cursorPosition)
+		if len(list) >= 1 {
+			word = list[len(list)-1]
+		}
 	}
+	return &word
 }
 
 func getPreviousWord(cmdLine string, cursorPosition int) *string {
 	list := BashInputToList(cmdLine, cursorPosition)
+	if isWordBreak(cmdLine, cursorPosition) {
+		// the current word is empty, so the previous word is the last one in the list
+		list = append(list, "")
+	}
 	if len(list) >= 2 {
 		return &list[len(list)-2]
 	} else {
@@ -75,14 +106,69 @@ func getPreviousWord(cmdLine string, cursorPosition int) *string {
 	}
 }
 
+// isWordBreak checks if the character preceding the cursor ends a word (whitespace or equals)
+func isWordBreak(cmdLine string, cursorPosition int) bool {
+	if cursorPosition > len(cmdLine) {
+		cursorPosition = len(cmdLine)
+	}
+	if cursorPosition <= 0 {
+		return true
+	}
+	c := rune(cmdLine[cursorPosition-1])
+	return unicode.IsSpace(c) || (c == '=')
+}
+
+// CompletedWords returns the words preceding the cursor, excluding the partial word being completed
+func (input *BashInput) CompletedWords() []string {
+	list := BashInputToList(input.CmdLine, input.CursorPosition)
+	if (input.CurrentWord != nil) && (len(*input.CurrentWord) > 0) && (len(list) > 0) {
+		list = list[:len(list)-1]
+	}
+	return list
+}
+
+// MatchCurrentWord returns the first name which starts with the current word
+func (input *BashInput) MatchCurrentWord(names ...string) (string, bool) {
+	var prefix string
+	if input.CurrentWord != nil {
+		prefix = *input.CurrentWord
+	}
+	return input.MatchPrefix(prefix, names...)
+}
+
+// MatchPrefix returns the first name which starts with prefix (honoring IgnoreCase)
+func (input *BashInput) MatchPrefix(prefix string, names ...string) (string, bool) {
+	if input.IgnoreCase {
+		prefix = strings.ToLower(prefix)
+	}
+	for _, name := range names {
+		if len(name) == 0 {
+			continue
+		}
+		candidate := name
+		if input.IgnoreCase {
+			candidate = strings.ToLower(candidate)
+		}
+		if strings.HasPrefix(candidate, prefix) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
 func BashInputToList(cmdLine string, maxLen int) []string {
 	var list []string
 
 	var state = NADA
 	var startOfWord = 0
-	var i = 0
-	var c rune = 0
-	for i, c = range cmdLine {
+	var endOfInput = 0
+	for i, c := range cmdLine {
+		// make sure we only consider maxLen characters
+		if i >= maxLen {
+			break
+		}
+		endOfInput = i + utf8.RuneLen(c)
+
 		var gotWord = false
 		switch state {
 		case NADA:
@@ -117,19 +203,13 @@ func BashInputToList(cmdLine string, maxLen int) []string {
 			list = append(list, word)
 			// change state
 			state = NADA
-			gotWord = false
 			startOfWord = 0
 		}
-		// make sure we only consider maxLen characters
-		if i >= maxLen {
-			break
-		}
 	}
 
 	// check if we have a remaining word in the buffer
-	if (state != NADA) && (startOfWord != 0) {
-		// make sure we don't consider more characters than allowed
-		word := cmdLine[startOfWord : i+1]
+	if state != NADA {
+		word := cmdLine[startOfWord:endOfInput]
 		list = append(list, word)
 	}
 
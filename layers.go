@@ -0,0 +1,296 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// The database layers, in overlay order: a root command in the user layer shadows the system layer's
+// command of the same name, and a tombstone in the user layer hides the system layer's command.
+const (
+	DBLayerUser   = "user"
+	DBLayerSystem = "system"
+)
+
+var DBLayers = []string{DBLayerUser, DBLayerSystem}
+
+// BceDBLayer is an open database layer
+type BceDBLayer struct {
+	Name string
+	Conn *sql.DB
+}
+
+// DBLayerPath locates the database of a layer. The user layer is the --db flag, then $BCE_DB, then the XDG data home.
+// The system layer is $BCE_SYSTEM_DB, then the system directory.
+func DBLayerPath(layer string) (string, error) {
+	switch layer {
+	case DBLayerUser:
+		if len(DBPathFlag) > 0 {
+			return DBPathFlag, nil
+		}
+		if path := os.Getenv(DBPathEnvVar); len(path) > 0 {
+			return path, nil
+		}
+		return DBUserPath()
+	case DBLayerSystem:
+		if path := os.Getenv(DBSystemPathEnvVar); len(path) > 0 {
+			return path, nil
+		}
+		return filepath.Join(DBSystemDir, DBFilename), nil
+	}
+	return "", errors.New("unknown database layer: " + layer)
+}
+
+// DBOpenLayer opens the database of a single layer, creating it (and its directory) if needed.
+// A new database is seeded from the embedded specs.
+func DBOpenLayer(layer string) (*sql.DB, error) {
+	path, err := DBLayerPath(layer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := DBOpen(path)
+	if err != nil {
+		return nil, err
+	}
+
+	schemaVersion, err := DBGetSchemaVersion(conn)
+	if (err == nil) && (layer == DBLayerSystem) {
+		// every user reads the system database read-only, which WAL doesn't allow without write access to its directory
+		_, err = conn.Exec("PRAGMA journal_mode = DELETE;")
+	}
+	if err == nil {
+		err = DBEnsureSchema(conn)
+	}
+	if (err == nil) && (schemaVersion == 0) {
+		err = dbSeedNewLayer(layer, conn)
+	}
+	if err != nil {
+		DBClose(conn)
+		return nil, err
+	}
+	return conn, nil
+}
+
+// DBOpenLayers opens the layers which have a database, in overlay order
+func DBOpenLayers() ([]BceDBLayer, error) {
+	var layers []BceDBLayer
+	var paths []string
+
+	for _, layer := range DBLayers {
+		path, err := DBLayerPath(layer)
+		if err != nil {
+			DBCloseLayers(layers)
+			return nil, err
+		}
+		paths = append(paths, path)
+		if !fileExists(path) {
+			continue
+		}
+
+		var conn *sql.DB
+		if layer == DBLayerSystem {
+			conn, err = dbOpenSystemLayer(path)
+		} else {
+			conn, err = DBOpen(path)
+			if err == nil {
+				err = DBEnsureSchema(conn)
+				if err != nil {
+					DBClose(conn)
+				}
+			}
+		}
+		if err != nil {
+			DBCloseLayers(layers)
+			return nil, errors.New(path + ": " + err.Error())
+		}
+		if conn != nil {
+			layers = append(layers, BceDBLayer{Name: layer, Conn: conn})
+		}
+	}
+
+	// without any database, the user's is created from the embedded specs
+	if len(layers) == 0 {
+		conn, err := DBOpenLayer(DBLayerUser)
+		if err != nil {
+			return nil, errors.New("no completion database found (expected " + paths[0] + " or " + paths[1] + "): " + err.Error())
+		}
+		layers = append(layers, BceDBLayer{Name: DBLayerUser, Conn: conn})
+	}
+	return layers, nil
+}
+
+// dbOpenSystemLayer opens the system database read-only, for completion. It is shared by every user, and only written
+// (and upgraded) with --layer system, so a database of another schema version is skipped (returning nil), not migrated.
+func dbOpenSystemLayer(path string) (*sql.DB, error) {
+	conn, err := DBOpenReadOnly(path)
+	if err != nil {
+		return nil, err
+	}
+	schemaVersion, err := DBGetSchemaVersion(conn)
+	if err != nil {
+		DBClose(conn)
+		return nil, err
+	}
+	if schemaVersion != DBSchemaVersion {
+		log.Printf("Skipping the system database %s: its schema version is %d, not %d (it is upgraded by writing to it with --layer system)",
+			path, schemaVersion, DBSchemaVersion)
+		DBClose(conn)
+		return nil, nil
+	}
+	return conn, nil
+}
+
+// DBOpenLayerOrLayers opens the one layer, if given, otherwise the layers which have a database
+func DBOpenLayerOrLayers(layer string) ([]BceDBLayer, error) {
+	if len(layer) == 0 {
+		return DBOpenLayers()
+	}
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return nil, err
+	}
+	return []BceDBLayer{{Name: layer, Conn: conn}}, nil
+}
+
+func DBCloseLayers(layers []BceDBLayer) {
+	for _, layer := range layers {
+		DBClose(layer.Conn)
+	}
+}
+
+// DBQueryCommandLayered loads a root command from the top-most layer which defines it,
+// returning nil if it doesn't exist, or has been shadowed or hidden by an upper layer
+func DBQueryCommandLayered(layers []BceDBLayer, cmdName string) (*BceCommand, error) {
+	return dbQueryLayered(layers, cmdName, func(conn *sql.DB) (*BceCommand, error) {
+		return DBQueryCommand(conn, cmdName)
+	})
+}
+
+// DBQueryCommandPathLayered is DBQueryCommandLayered, loading only the part of the command needed to complete the words
+func DBQueryCommandPathLayered(layers []BceDBLayer, cmdName string, words []string) (*BceCommand, error) {
+	return dbQueryLayered(layers, cmdName, func(conn *sql.DB) (*BceCommand, error) {
+		return DBQueryCommandPath(conn, cmdName, words)
+	})
+}
+
+func dbQueryLayered(layers []BceDBLayer, cmdName string, query func(conn *sql.DB) (*BceCommand, error)) (*BceCommand, error) {
+	// the root command names (and tombstones) of the layers above the current one
+	var shadowed []string
+
+	for _, layer := range layers {
+		cmd, err := query(layer.Conn)
+		if err != nil {
+			return nil, err
+		}
+		if cmd != nil {
+			if contains(shadowed, cmd.Name) {
+				return nil, nil
+			}
+			return cmd, nil
+		}
+
+		names, err := DBQueryRootCommandNames(layer.Conn)
+		if err != nil {
+			return nil, err
+		}
+		tombstones, err := DBQueryCommandTombstones(layer.Conn)
+		if err != nil {
+			return nil, err
+		}
+		shadowed = append(shadowed, names...)
+		shadowed = append(shadowed, tombstones...)
+		if contains(shadowed, cmdName) {
+			return nil, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// DBQueryRootCommandsLayered returns the sorted names of the visible root commands, and the layer providing each
+func DBQueryRootCommandsLayered(layers []BceDBLayer) ([]string, map[string]BceDBLayer, error) {
+	var names []string
+	var owners = make(map[string]BceDBLayer)
+	var shadowed []string
+
+	for _, layer := range layers {
+		layerNames, err := DBQueryRootCommandNames(layer.Conn)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, name := range layerNames {
+			if !contains(shadowed, name) {
+				names = append(names, name)
+				owners[name] = layer
+			}
+		}
+
+		tombstones, err := DBQueryCommandTombstones(layer.Conn)
+		if err != nil {
+			return nil, nil, err
+		}
+		shadowed = append(shadowed, layerNames...)
+		shadowed = append(shadowed, tombstones...)
+	}
+
+	sort.Strings(names)
+	return names, owners, nil
+}
+
+// DBQueryCommandFromLayer loads a root command from a single layer, or through the overlay of all the layers
+// when no layer is given. It is an error for the command not to exist.
+func DBQueryCommandFromLayer(layer string, cmdName string) (*BceCommand, error) {
+	var cmd *BceCommand
+	if len(layer) > 0 {
+		conn, err := DBOpenLayer(layer)
+		if err != nil {
+			return nil, err
+		}
+		defer DBClose(conn)
+
+		cmd, err = DBQueryCommand(conn, cmdName)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		layers, err := DBOpenLayers()
+		if err != nil {
+			return nil, err
+		}
+		defer DBCloseLayers(layers)
+
+		cmd, err = DBQueryCommandLayered(layers, cmdName)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if cmd == nil {
+		return nil, errors.New("command not found: " + cmdName)
+	}
+	return cmd, nil
+}
+
+// processHideCommand adds (or removes) a tombstone for the command in a layer
+func processHideCommand(layer string, commandName string, hide bool) error {
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	if hide {
+		return DBInsertCommandTombstone(conn, commandName)
+	}
+	return DBDeleteCommandTombstone(conn, commandName)
+}
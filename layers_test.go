@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// copyTestFile copies a fixture into the test's temporary directory
+func copyTestFile(t testing.TB, src string, dest string) string {
+	t.Helper()
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ioutil.WriteFile(dest, data, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dest
+}
+
+// an older system database is skipped when completing, it isn't migrated (nor backed up) in place
+func TestDBOpenLayersSkipsOldSystemDB(t *testing.T) {
+	dir := t.TempDir()
+	systemPath := copyTestFile(t, "completion.db", filepath.Join(dir, "system.db"))
+	t.Setenv(DBSystemPathEnvVar, systemPath)
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+
+	layers, err := DBOpenLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DBCloseLayers(layers)
+	for _, layer := range layers {
+		if layer.Name == DBLayerSystem {
+			t.Error("the system layer was opened")
+		}
+	}
+
+	conn, err := DBOpenReadOnly(systemPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DBClose(conn)
+	version, err := DBGetSchemaVersion(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if version != 1 {
+		t.Errorf("the system database was changed to schema version %d", version)
+	}
+	if fileExists(systemPath + ".v1.bak") {
+		t.Error("the system database was backed up")
+	}
+}
+
+// a current system database is opened read-only
+func TestDBOpenLayersReadsSystemDB(t *testing.T) {
+	dir := t.TempDir()
+	systemPath := filepath.Join(dir, "system.db")
+	t.Setenv(DBSystemPathEnvVar, systemPath)
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+
+	// written (and seeded) with --layer system
+	conn, err := DBOpenLayer(DBLayerSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	DBClose(conn)
+
+	layers, err := DBOpenLayers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DBCloseLayers(layers)
+	if (len(layers) != 1) || (layers[0].Name != DBLayerSystem) {
+		t.Fatalf("expected only the system layer, got %v", layers)
+	}
+	_, err = layers[0].Conn.Exec("DELETE FROM command_tombstone;")
+	if err == nil {
+		t.Error("the system layer is writable")
+	}
+}
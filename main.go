@@ -4,17 +4,20 @@ import (
 	"errors"
 	"fmt"
 	"github.com/mattn/go-sqlite3"
+	"io"
 	"log"
 	"os"
+	"strconv"
+	"strings"
 )
 
-const DBFilename = "completion.db"
+// DebugEnvVar enables diagnostic output (on stderr) during completion
+const DebugEnvVar = "BCE_DEBUG"
 
 func main() {
 	var err error
-	var args = os.Args[1:]
 
-	if len(args) <= 1 {
+	if isCompletionRequest() {
 		err = processCompletion()
 	} else {
 		err = processCli()
@@ -27,131 +30,228 @@ func main() {
 	}
 }
 
-func processCompletion() error {
-	var sqliteVersion, _, _ = sqlite3.Version()
-	fmt.Println("SQLite version:", sqliteVersion)
+// isCompletionRequest determines if bce was invoked by bash (complete -C), which passes the
+// command, current word and previous word as arguments and the command line in the environment
+func isCompletionRequest() bool {
+	if _, ok := os.LookupEnv(BashLineVar); ok {
+		return true
+	}
+	return len(os.Args[1:]) == 0
+}
 
-	conn, err := DBOpen(DBFilename)
+func isDebugEnabled() bool {
+	debug, err := strconv.ParseBool(os.Getenv(DebugEnvVar))
+	return (err == nil) && debug
+}
+
+func processCompletion() error {
+	input, err := CreateCompletionInput()
 	if err != nil {
 		return err
 	}
-	defer DBClose(conn)
 
-	schemaVersion, err := DBGetSchemaVersion(conn)
+	recommendationList, err := collectCompletions(input)
 	if err != nil {
 		return err
 	}
-	if schemaVersion == 0 {
-		// create the schema
-		err = DBCreateSchema(conn)
-		if err != nil {
-			return err
+
+	printBashCandidates(os.Stdout, recommendationList)
+
+	return nil
+}
+
+// collectCompletions loads the command named in the input and builds its recommendations,
+// independent of the shell frontend which will format them
+func collectCompletions(input *BashInput) ([]BceRecommendation, error) {
+	// stdout is reserved for the candidates consumed by the shell, so diagnostics go to stderr (if enabled)
+	debug := isDebugEnabled()
+	if !debug {
+		log.SetOutput(io.Discard)
+	}
+
+	if input.CmdName == nil {
+		err := errors.New("no command in input")
+		return nil, err
+	}
+
+	// a running daemon has the commands loaded already
+	recommendationList, err := daemonCollectCompletions(input)
+	if err == nil {
+		return recommendationList, nil
+	}
+	log.Println("daemon unavailable:", err)
+
+	// the compiled indexes are preferred to the databases, when every layer has one
+	cmd, ok, err := IndexQueryCommandPathLayered(*input.CmdName, input.CompletedWords())
+	if err != nil {
+		log.Println("index unavailable:", err)
+	} else if ok {
+		log.Println("completing from the index")
+		defer GeneratorCacheClose()
+		if cmd == nil {
+			log.Println("unknown command:", *input.CmdName)
+			return nil, nil
 		}
-		schemaVersion, err = DBGetSchemaVersion(conn)
+		return completeCommand(cmd, input), nil
 	}
-	if schemaVersion != DBSchemaVersion {
-		err := errors.New("schema version mismatch")
-		return err
+
+	var sqliteVersion, _, _ = sqlite3.Version()
+	log.Println("SQLite version:", sqliteVersion)
+
+	layers, err := DBOpenLayers()
+	if err != nil {
+		return nil, err
 	}
+	defer DBCloseLayers(layers)
+	defer GeneratorCacheClose()
 
-	input, err := CreateCompletionInput()
+	// search for the command directly (only loading the sub-commands on the command line)
+	cmd, err = DBQueryCommandPathLayered(layers, *input.CmdName, input.CompletedWords())
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if input.CmdName == nil {
-		err := errors.New("no command in input")
-		return err
+	if cmd == nil {
+		log.Println("unknown command:", *input.CmdName)
+		return nil, nil
 	}
 
-	fmt.Println("input:", input.CmdLine)
-	fmt.Println("command:", input.CmdName)
-	fmt.Println("current word:", input.CurrentWord)
-	fmt.Println("previous word:", input.PreviousWord)
+	return completeCommand(cmd, input), nil
+}
 
-	// search for the command directly (load all descendents)
-	cmd, err := DBQueryCommand(conn, *input.CmdName)
-	if err != nil {
-		return err
+// completeCommand builds the recommendations for the input, from the command loaded for it (which gets pruned)
+func completeCommand(cmd *BceCommand, input *BashInput) []BceRecommendation {
+	debug := isDebugEnabled()
+
+	log.Println("input:", input.CmdLine)
+	log.Println("command:", *input.CmdName)
+	if input.CurrentWord != nil {
+		log.Println("current word:", *input.CurrentWord)
+	}
+	if input.PreviousWord != nil {
+		log.Println("previous word:", *input.PreviousWord)
 	}
 
-	fmt.Println("\nCommand Tree (Database)")
-	printCommandTree(cmd, 0)
+	if debug {
+		fmt.Fprintln(os.Stderr, "\nCommand Tree (Database)")
+		printCommandTree(os.Stderr, cmd, 0)
+	}
+
+	// resolve the words typed so far, before the typed args and sub-commands are pruned
+	cmdLine := cmd.ParseCommandLine(input.CompletedWords())
+	input.ArgValues = cmdLine.ArgValues
+	input.Positionals = cmdLine.Positionals
+	positional := cmdLine.CurrentPositional()
 
 	// remove non-relevant command data
 	cmd.prune(input)
 
-	fmt.Println("\nCommand tree (Pruned)")
-	printCommandTree(cmd, 0)
+	if debug {
+		fmt.Fprintln(os.Stderr, "\nCommand tree (Pruned)")
+		printCommandTree(os.Stderr, cmd, 0)
+	}
 
 	// build the command recommendations
 	var hasRequired = true
 	var recommendationList = cmd.CollectRequiredRecommendations(input)
 	if len(recommendationList) == 0 {
 		hasRequired = false
-		recommendationList = cmd.CollectOptionalRecommendations(input)
+		var positionalList []BceRecommendation
+		if (positional != nil) && ((input.CurrentWord == nil) || !strings.HasPrefix(*input.CurrentWord, "-")) {
+			positionalList = positional.CollectRecommendations(input)
+		}
+		if (len(positionalList) > 0) && positional.IsRequired() {
+			hasRequired = true
+			recommendationList = positionalList
+		} else {
+			recommendationList = append(positionalList, cmd.CollectOptionalRecommendations(input)...)
+		}
 	}
 
-	if hasRequired {
-		fmt.Println("\nRecommendations (Required)")
-	} else {
-		fmt.Println("\nRecommendations (Optional)")
+	if debug {
+		if hasRequired {
+			fmt.Fprintln(os.Stderr, "\nRecommendations (Required)")
+		} else {
+			fmt.Fprintln(os.Stderr, "\nRecommendations (Optional)")
+		}
+		printRecommendations(os.Stderr, recommendationList)
 	}
 
-	printRecommendations(recommendationList)
-
-	return nil
+	return recommendationList
 }
 
-func printCommandTree(cmd *BceCommand, level int) {
+func printCommandTree(w io.Writer, cmd *BceCommand, level int) {
 	// indent
 	for i := 0; i < level; i++ {
-		fmt.Print("  ")
+		fmt.Fprint(w, "  ")
 	}
 
-	fmt.Println("command:", cmd.Name)
+	fmt.Fprintln(w, "command:", cmd.Name)
 	if len(cmd.Aliases) > 0 {
 		// indent
 		for i := 0; i < level; i++ {
-			fmt.Print("  ")
+			fmt.Fprint(w, "  ")
 		}
-		fmt.Print("  Aliases: ")
+		fmt.Fprint(w, "  Aliases: ")
 		for _, alias := range cmd.Aliases {
-			fmt.Print(alias.Name, " ")
+			fmt.Fprint(w, alias.Name, " ")
 		}
-		fmt.Println()
+		fmt.Fprintln(w)
 	}
 
 	if len(cmd.Args) > 0 {
 		for _, arg := range cmd.Args {
 			// indent
 			for i := 0; i < level; i++ {
-				fmt.Print("  ")
+				fmt.Fprint(w, "  ")
 			}
-			fmt.Printf("  arg: %s (%s): %s\n", arg.LongName, arg.ShortName, arg.ArgType)
+			fmt.Fprintf(w, "  arg: %s (%s): %s\n", arg.LongName, arg.ShortName, arg.ArgType)
 
 			// print Opts
 			if len(arg.Opts) > 0 {
 				for _, opt := range arg.Opts {
 					// indent
 					for i := 0; i < level; i++ {
-						fmt.Print("  ")
+						fmt.Fprint(w, "  ")
 					}
-					fmt.Printf("    opt: %s\n", opt.Name)
+					fmt.Fprintf(w, "    opt: %s\n", opt.Name)
 				}
 			}
 		}
 	}
 
+	for _, positional := range cmd.Positionals {
+		// indent
+		for i := 0; i < level; i++ {
+			fmt.Fprint(w, "  ")
+		}
+		fmt.Fprintf(w, "  positional: %d %s (%s): %s\n", positional.Position, positional.Name, positional.Arity, positional.ArgType)
+		for _, opt := range positional.Opts {
+			// indent
+			for i := 0; i < level; i++ {
+				fmt.Fprint(w, "  ")
+			}
+			fmt.Fprintf(w, "    opt: %s\n", opt.Name)
+		}
+	}
+
 	// print sub-commands
 	if len(cmd.SubCommands) > 0 {
 		for _, subCmd := range cmd.SubCommands {
-			printCommandTree(&subCmd, level+1)
+			printCommandTree(w, &subCmd, level+1)
 		}
 	}
 }
 
-func printRecommendations(items []string) {
+func printRecommendations(w io.Writer, items []BceRecommendation) {
+	for _, item := range items {
+		fmt.Fprintln(w, item)
+	}
+}
+
+// printBashCandidates writes one bare candidate per line, the format expected from a `complete -C` command.
+// The alias decoration is omitted, since bash would insert it literally.
+func printBashCandidates(w io.Writer, items []BceRecommendation) {
 	for _, item := range items {
-		fmt.Println(item)
+		fmt.Fprintln(w, item.Name)
 	}
 }
@@ -0,0 +1,139 @@
+package main
+
+// A merge import updates the commands already in the database from the imported ones, rather than replacing them.
+// Nodes are matched as by diffCommand (uuid, then name), and a matched node keeps its uuid. The imported commands
+// add sub-commands, aliases, args, positionals and opts, and update the attributes (descriptions, types, ...) of
+// matched nodes. What the import doesn't have is kept, unless the merge prunes it.
+
+// BceImportOptions chooses how an import treats the commands already in the database
+type BceImportOptions struct {
+	// Merge into the existing commands, rather than replacing them
+	Merge bool
+	// Prune removes (when merging) what the imported commands don't have
+	Prune bool
+	// DryRun prints the changes, without making them
+	DryRun bool
+	// Source is the imported file (or URL), and Checksum its content's, recorded in the revision history
+	Source   string
+	Checksum string
+}
+
+// mergeCommand merges the imported command into the existing one, returning the result (the arguments are unchanged)
+func mergeCommand(existing *BceCommand, imported *BceCommand, prune bool) BceCommand {
+	merged := BceCommand{
+		Uuid:          existing.Uuid,
+		Name:          imported.Name,
+		ParentCmdUuid: existing.ParentCmdUuid,
+	}
+
+	// aliases
+	for _, alias := range existing.Aliases {
+		if !prune || hasAlias(imported.Aliases, alias.Name) {
+			merged.Aliases = append(merged.Aliases, alias)
+		}
+	}
+	for _, alias := range imported.Aliases {
+		if !hasAlias(existing.Aliases, alias.Name) {
+			alias.CmdUuid = merged.Uuid
+			merged.Aliases = append(merged.Aliases, alias)
+		}
+	}
+
+	// args, the matched ones take the imported attributes
+	matched := map[int]bool{}
+	var added []BceCommandArg
+	var mergedArgs = make([]BceCommandArg, len(existing.Args))
+	for _, arg := range imported.Args {
+		i := matchArg(existing.Args, arg, matched)
+		if i < 0 {
+			arg.CmdUuid = merged.Uuid
+			added = append(added, arg)
+			continue
+		}
+		matched[i] = true
+		old := existing.Args[i]
+		arg.Uuid = old.Uuid
+		arg.CmdUuid = merged.Uuid
+		arg.Opts = mergeOpts(old.Opts, arg.Opts, old.Uuid, prune)
+		mergedArgs[i] = arg
+	}
+	for i, arg := range existing.Args {
+		if matched[i] {
+			merged.Args = append(merged.Args, mergedArgs[i])
+		} else if !prune {
+			merged.Args = append(merged.Args, arg)
+		}
+	}
+	merged.Args = append(merged.Args, added...)
+
+	// positionals
+	matched = map[int]bool{}
+	var addedPositionals []BceCommandPositional
+	var mergedPositionals = make([]BceCommandPositional, len(existing.Positionals))
+	for _, positional := range imported.Positionals {
+		i := matchPositional(existing.Positionals, positional, matched)
+		if i < 0 {
+			positional.CmdUuid = merged.Uuid
+			addedPositionals = append(addedPositionals, positional)
+			continue
+		}
+		matched[i] = true
+		old := existing.Positionals[i]
+		positional.Uuid = old.Uuid
+		positional.CmdUuid = merged.Uuid
+		positional.Opts = mergeOpts(old.Opts, positional.Opts, old.Uuid, prune)
+		mergedPositionals[i] = positional
+	}
+	for i, positional := range existing.Positionals {
+		if matched[i] {
+			merged.Positionals = append(merged.Positionals, mergedPositionals[i])
+		} else if !prune {
+			merged.Positionals = append(merged.Positionals, positional)
+		}
+	}
+	merged.Positionals = append(merged.Positionals, addedPositionals...)
+
+	// sub-commands (recursively)
+	matched = map[int]bool{}
+	var addedSubCmds []BceCommand
+	var mergedSubCmds = make([]BceCommand, len(existing.SubCommands))
+	for i := range imported.SubCommands {
+		subCmd := &imported.SubCommands[i]
+		j := matchSubCommand(existing.SubCommands, subCmd, matched)
+		if j < 0 {
+			addedSubCmd := *subCmd
+			addedSubCmd.ParentCmdUuid = &merged.Uuid
+			addedSubCmds = append(addedSubCmds, addedSubCmd)
+			continue
+		}
+		matched[j] = true
+		mergedSubCmds[j] = mergeCommand(&existing.SubCommands[j], subCmd, prune)
+	}
+	for i, subCmd := range existing.SubCommands {
+		if matched[i] {
+			merged.SubCommands = append(merged.SubCommands, mergedSubCmds[i])
+		} else if !prune {
+			merged.SubCommands = append(merged.SubCommands, subCmd)
+		}
+	}
+	merged.SubCommands = append(merged.SubCommands, addedSubCmds...)
+
+	return merged
+}
+
+// mergeOpts merges the imported opts of an arg (or positional) into its existing ones
+func mergeOpts(existing []BceCommandOpt, imported []BceCommandOpt, argUuid string, prune bool) []BceCommandOpt {
+	var merged []BceCommandOpt
+	for _, opt := range existing {
+		if !prune || hasOpt(imported, opt.Name) {
+			merged = append(merged, opt)
+		}
+	}
+	for _, opt := range imported {
+		if !hasOpt(existing, opt.Name) {
+			opt.ArgUuid = argUuid
+			merged = append(merged, opt)
+		}
+	}
+	return merged
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// BceMigration upgrades the schema from the previous version to Version
+type BceMigration struct {
+	Version     int
+	Description string
+	SQL         string
+}
+
+// dbMigrations is the ordered registry of schema upgrades. The last entry must match DBSchemaVersion,
+// and DBCreateSchema must produce the same schema as applying every migration to version 1.
+var dbMigrations = []BceMigration{
+	{
+		Version:     2,
+		Description: "add DIRECTORY arg type and arg file_filter",
+		// the CHECK constraint can't be altered, so the table is rebuilt
+		SQL: `
+			CREATE TABLE command_arg_v2 (
+				uuid TEXT PRIMARY KEY,
+				cmd_uuid TEXT NOT NULL,
+				arg_type TEXT NOT NULL
+					CHECK (arg_type IN ('NONE', 'OPTION', 'FILE', 'DIRECTORY', 'TEXT')),
+				description TEXT NOT NULL,
+				long_name TEXT,
+				short_name TEXT,
+				file_filter TEXT,
+				FOREIGN KEY(cmd_uuid) REFERENCES command(uuid) ON DELETE CASCADE,
+				CHECK ( (long_name IS NOT NULL) OR (short_name IS NOT NULL) )
+			);
+			INSERT INTO command_arg_v2
+				(uuid, cmd_uuid, arg_type, description, long_name, short_name)
+			SELECT uuid, cmd_uuid, arg_type, description, NULLIF(long_name, ''), NULLIF(short_name, '')
+			FROM command_arg;
+			DROP TABLE command_arg;
+			ALTER TABLE command_arg_v2 RENAME TO command_arg;
+			CREATE INDEX command_arg_cmd_uuid_idx
+				ON command_arg (cmd_uuid);
+			CREATE UNIQUE INDEX command_arg_longname_idx
+				ON command_arg (cmd_uuid, long_name);
+		`,
+	},
+	{
+		Version:     3,
+		Description: "add command tombstones",
+		SQL:         sqlCreateCompletionCommandTombstone,
+	},
+	{
+		Version:     4,
+		Description: "add positional arguments",
+		SQL: `
+			CREATE TABLE command_positional (
+				uuid TEXT PRIMARY KEY,
+				cmd_uuid TEXT NOT NULL,
+				position INTEGER NOT NULL
+					CHECK (position > 0),
+				name TEXT NOT NULL,
+				arity TEXT NOT NULL
+					CHECK (arity IN ('EXACTLY', 'OPTIONAL', 'VARIADIC')),
+				arg_type TEXT NOT NULL
+					CHECK (arg_type IN ('OPTION', 'FILE', 'DIRECTORY', 'TEXT')),
+				description TEXT NOT NULL,
+				file_filter TEXT,
+				FOREIGN KEY(cmd_uuid) REFERENCES command(uuid) ON DELETE CASCADE
+			);
+			CREATE INDEX command_positional_cmd_uuid_idx
+				ON command_positional (cmd_uuid);
+			CREATE UNIQUE INDEX command_positional_position_idx
+				ON command_positional (cmd_uuid, position);
+
+			CREATE TABLE command_positional_opt (
+				uuid TEXT PRIMARY KEY,
+				positional_uuid TEXT NOT NULL,
+				name TEXT NOT NULL,
+				FOREIGN KEY(positional_uuid) REFERENCES command_positional(uuid) ON DELETE CASCADE
+			);
+			CREATE INDEX command_positional_opt_positional_idx
+				ON command_positional_opt (positional_uuid);
+			CREATE UNIQUE INDEX command_positional_opt_name_idx
+				ON command_positional_opt (positional_uuid, name);
+		`,
+	},
+	{
+		Version:     5,
+		Description: "add GENERATOR arg type and generator command",
+		// the CHECK constraints can't be altered, so the tables are rebuilt
+		SQL: `
+			CREATE TABLE command_arg_v5 (
+				uuid TEXT PRIMARY KEY,
+				cmd_uuid TEXT NOT NULL,
+				arg_type TEXT NOT NULL
+					CHECK (arg_type IN ('NONE', 'OPTION', 'FILE', 'DIRECTORY', 'TEXT', 'GENERATOR')),
+				description TEXT NOT NULL,
+				long_name TEXT,
+				short_name TEXT,
+				file_filter TEXT,
+				generator TEXT,
+				FOREIGN KEY(cmd_uuid) REFERENCES command(uuid) ON DELETE CASCADE,
+				CHECK ( (long_name IS NOT NULL) OR (short_name IS NOT NULL) )
+			);
+			INSERT INTO command_arg_v5
+				(uuid, cmd_uuid, arg_type, description, long_name, short_name, file_filter)
+			SELECT uuid, cmd_uuid, arg_type, description, long_name, short_name, file_filter
+			FROM command_arg;
+			DROP TABLE command_arg;
+			ALTER TABLE command_arg_v5 RENAME TO command_arg;
+			CREATE INDEX command_arg_cmd_uuid_idx
+				ON command_arg (cmd_uuid);
+			CREATE UNIQUE INDEX command_arg_longname_idx
+				ON command_arg (cmd_uuid, long_name);
+
+			CREATE TABLE command_positional_v5 (
+				uuid TEXT PRIMARY KEY,
+				cmd_uuid TEXT NOT NULL,
+				position INTEGER NOT NULL
+					CHECK (position > 0),
+				name TEXT NOT NULL,
+				arity TEXT NOT NULL
+					CHECK (arity IN ('EXACTLY', 'OPTIONAL', 'VARIADIC')),
+				arg_type TEXT NOT NULL
+					CHECK (arg_type IN ('OPTION', 'FILE', 'DIRECTORY', 'TEXT', 'GENERATOR')),
+				description TEXT NOT NULL,
+				file_filter TEXT,
+				generator TEXT,
+				FOREIGN KEY(cmd_uuid) REFERENCES command(uuid) ON DELETE CASCADE
+			);
+			INSERT INTO command_positional_v5
+				(uuid, cmd_uuid, position, name, arity, arg_type, description, file_filter)
+			SELECT uuid, cmd_uuid, position, name, arity, arg_type, description, file_filter
+			FROM command_positional;
+			DROP TABLE command_positional;
+			ALTER TABLE command_positional_v5 RENAME TO command_positional;
+			CREATE INDEX command_positional_cmd_uuid_idx
+				ON command_positional (cmd_uuid);
+			CREATE UNIQUE INDEX command_positional_position_idx
+				ON command_positional (cmd_uuid, position);
+		`,
+	},
+	{
+		Version:     6,
+		Description: "add generator TTL",
+		SQL: `
+			ALTER TABLE command_arg ADD COLUMN generator_ttl INTEGER;
+			ALTER TABLE command_positional ADD COLUMN generator_ttl INTEGER;
+		`,
+	},
+	{
+		Version:     7,
+		Description: "scope command name uniqueness to siblings",
+		// sub-commands of different commands may share a name (e.g. get), root commands stay unique
+		SQL: `
+			DROP INDEX command_name_idx;
+			CREATE UNIQUE INDEX command_name_idx
+				ON command (COALESCE(parent_cmd, ''), name);
+		`,
+	},
+	{
+		Version:     8,
+		Description: "add command revision history",
+		SQL:         sqlCreateCommandRevision,
+	},
+}
+
+// DBMigrate upgrades the database from fromVersion to DBSchemaVersion. The database file is backed up first,
+// and all the migrations are applied in a single transaction, so a failure leaves the database untouched.
+func DBMigrate(conn *sql.DB, fromVersion int) error {
+	if (len(dbMigrations) == 0) || (dbMigrations[len(dbMigrations)-1].Version != DBSchemaVersion) {
+		return fmt.Errorf("no schema migration to version %d", DBSchemaVersion)
+	}
+
+	err := dbBackup(conn, fromVersion)
+	if err != nil {
+		return fmt.Errorf("unable to back up the database before migrating: %w", err)
+	}
+
+	// foreign keys must be disabled (outside the transaction) while tables are rebuilt, so a dedicated connection is used
+	ctx := context.Background()
+	dbConn, err := conn.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	_, err = dbConn.ExecContext(ctx, "PRAGMA foreign_keys = 0;")
+	if err != nil {
+		return err
+	}
+	defer dbConn.ExecContext(ctx, "PRAGMA foreign_keys = 1;")
+
+	tx, err := dbConn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range dbMigrations {
+		if migration.Version <= fromVersion {
+			continue
+		}
+		log.Println("Migrating schema to version", migration.Version, "-", migration.Description)
+		_, err = tx.Exec(migration.SQL)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("schema migration to version %d failed: %w", migration.Version, err)
+		}
+	}
+
+	// the rebuilt tables must still satisfy the foreign keys
+	rows, err := tx.Query("PRAGMA foreign_key_check;")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	hasViolation := rows.Next()
+	rows.Close()
+	if hasViolation {
+		_ = tx.Rollback()
+		return fmt.Errorf("schema migration to version %d left foreign key violations", DBSchemaVersion)
+	}
+
+	_, err = tx.Exec("PRAGMA user_version = " + strconv.Itoa(DBSchemaVersion) + ";")
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// dbBackup copies the database alongside itself (e.g. completion.db.v1.bak), replacing an older backup of the same version
+func dbBackup(conn *sql.DB, version int) error {
+	path, err := DBFilePath(conn)
+	if err != nil {
+		return err
+	}
+	if len(path) == 0 {
+		// in-memory or temporary database
+		return nil
+	}
+
+	backupPath := path + ".v" + strconv.Itoa(version) + ".bak"
+	err = os.Remove(backupPath)
+	if (err != nil) && !os.IsNotExist(err) {
+		return err
+	}
+
+	log.Println("Backing up database to", backupPath)
+	_, err = conn.Exec("VACUUM INTO '" + strings.ReplaceAll(backupPath, "'", "''") + "';")
+	return err
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/migrations/vN.db is a database of schema version N, as written by the bce of that version, holding rows of
+// each feature it added. Adding a migration means adding the fixture of the version it migrates from.
+
+func queryTableCounts(t *testing.T, conn *sql.DB) map[string]int {
+	t.Helper()
+	rows, err := conn.Query("SELECT name FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%';")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tables []string
+	for rows.Next() {
+		var table string
+		if err := rows.Scan(&table); err != nil {
+			t.Fatal(err)
+		}
+		tables = append(tables, table)
+	}
+	rows.Close()
+
+	counts := map[string]int{}
+	for _, table := range tables {
+		var count int
+		err := conn.QueryRow("SELECT COUNT(*) FROM " + table + ";").Scan(&count)
+		if err != nil {
+			t.Fatal(err)
+		}
+		counts[table] = count
+	}
+	return counts
+}
+
+// querySchema lists the tables and indexes, with their columns
+func querySchema(t *testing.T, conn *sql.DB) string {
+	t.Helper()
+	rows, err := conn.Query(`
+		SELECT m.type, m.name, IFNULL(GROUP_CONCAT(c.name), '')
+		FROM sqlite_master m
+		LEFT JOIN pragma_table_info(m.name) c ON m.type = 'table'
+		WHERE m.name NOT LIKE 'sqlite_%'
+		GROUP BY m.type, m.name
+		ORDER BY m.type, m.name;`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rows.Close()
+	var schema []string
+	for rows.Next() {
+		var kind, name, columns string
+		if err := rows.Scan(&kind, &name, &columns); err != nil {
+			t.Fatal(err)
+		}
+		schema = append(schema, kind+" "+name+"("+strings.ToLower(columns)+")")
+	}
+	return strings.Join(schema, "\n")
+}
+
+func TestDBMigrateFixtures(t *testing.T) {
+	created, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "created.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer created.Close()
+	err = DBCreateSchema(created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdSchema := querySchema(t, created)
+
+	for version := 1; version < DBSchemaVersion; version++ {
+		t.Run(fmt.Sprintf("v%d", version), func(t *testing.T) {
+			path := copyTestFile(t, filepath.Join("testdata", "migrations", fmt.Sprintf("v%d.db", version)), filepath.Join(t.TempDir(), "completion.db"))
+			conn, err := DBOpen(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer DBClose(conn)
+
+			fixtureVersion, err := DBGetSchemaVersion(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fixtureVersion != version {
+				t.Fatalf("the fixture is version %d", fixtureVersion)
+			}
+			before := queryTableCounts(t, conn)
+
+			err = DBEnsureSchema(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			migratedVersion, err := DBGetSchemaVersion(conn)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if migratedVersion != DBSchemaVersion {
+				t.Errorf("migrated to version %d", migratedVersion)
+			}
+
+			// the rows survive
+			after := queryTableCounts(t, conn)
+			for table, count := range before {
+				if after[table] != count {
+					t.Errorf("%s: %d rows before, %d after", table, count, after[table])
+				}
+			}
+
+			// a missing arg name is NULL, as the names are unique per command
+			var emptyNames int
+			err = conn.QueryRow("SELECT COUNT(*) FROM command_arg WHERE (long_name = '') OR (short_name = '');").Scan(&emptyNames)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if emptyNames > 0 {
+				t.Errorf("%d args with an empty name", emptyNames)
+			}
+
+			var problems int
+			err = conn.QueryRow("SELECT COUNT(*) FROM pragma_foreign_key_check;").Scan(&problems)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if problems > 0 {
+				t.Errorf("%d foreign key violations", problems)
+			}
+			var integrity string
+			err = conn.QueryRow("PRAGMA integrity_check;").Scan(&integrity)
+			if (err != nil) || (integrity != "ok") {
+				t.Errorf("integrity check: %s %v", integrity, err)
+			}
+
+			// the same schema as a new database
+			if schema := querySchema(t, conn); schema != createdSchema {
+				t.Errorf("the migrated schema:\n%s\ndiffers from a new database's:\n%s", schema, createdSchema)
+			}
+
+			// the backup is the database before the migration
+			backupPath := fmt.Sprintf("%s.v%d.bak", path, version)
+			backup, err := DBOpenReadOnly(backupPath)
+			if err != nil {
+				t.Fatalf("no backup: %v", err)
+			}
+			defer DBClose(backup)
+			backupVersion, err := DBGetSchemaVersion(backup)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backupVersion != version {
+				t.Errorf("the backup is version %d", backupVersion)
+			}
+		})
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"log"
+	"strings"
+)
+
+// BceCommandLine is what has been typed before the cursor, resolved against the command tree
+type BceCommandLine struct {
+	// Path is the command followed by the sub-commands which have been typed
+	Path []*BceCommand
+	// Positionals are the positional values typed for the innermost sub-command
+	Positionals []string
+	// ArgValues are the values typed for the args, by long and short name
+	ArgValues map[string]string
+	// IsArgValue is set when the word at the cursor is the value of an arg
+	IsArgValue bool
+}
+
+// ParseCommandLine walks the completed words from the root command: sub-commands are descended into,
+// args (and their values) are collected, and the remaining words are positionals.
+// It must be called before pruning, as pruning removes the args and sub-commands which have been used.
+func (cmd *BceCommand) ParseCommandLine(words []string) *BceCommandLine {
+	cmdLine, _ := cmd.parseCommandLine(words, func(current *BceCommand, word string) (*BceCommand, error) {
+		return current.findSubCommand(word), nil
+	})
+	return cmdLine
+}
+
+// parseCommandLine walks the words, using findSubCommand to resolve (and possibly load) the sub-commands
+func (cmd *BceCommand) parseCommandLine(words []string, findSubCommand func(current *BceCommand, word string) (*BceCommand, error)) (*BceCommandLine, error) {
+	cmdLine := &BceCommandLine{Path: []*BceCommand{cmd}, ArgValues: map[string]string{}}
+	for i := 1; i < len(words); i++ {
+		word := words[i]
+		if strings.HasPrefix(word, "-") {
+			arg := findArg(cmdLine.Path, word)
+			if (arg != nil) && (arg.ArgType != ArgTypeNone) {
+				// the next word is the arg's value
+				i++
+				if i >= len(words) {
+					// the word at the cursor is the arg's value
+					cmdLine.IsArgValue = true
+					break
+				}
+				for _, name := range arg.argNames() {
+					cmdLine.ArgValues[name] = words[i]
+				}
+			}
+			continue
+		}
+		if len(cmdLine.Positionals) == 0 {
+			subCmd, err := findSubCommand(cmdLine.Path[len(cmdLine.Path)-1], word)
+			if err != nil {
+				return nil, err
+			}
+			if subCmd != nil {
+				cmdLine.Path = append(cmdLine.Path, subCmd)
+				continue
+			}
+		}
+		cmdLine.Positionals = append(cmdLine.Positionals, word)
+	}
+	return cmdLine, nil
+}
+
+// CurrentPositional finds the positional being completed, from the number of positionals already typed
+func (cmdLine *BceCommandLine) CurrentPositional() *BceCommandPositional {
+	if cmdLine.IsArgValue {
+		// the word at the cursor is the value of an arg, not a positional
+		return nil
+	}
+	current := cmdLine.Path[len(cmdLine.Path)-1]
+	positional := current.positionalAt(len(cmdLine.Positionals) + 1)
+	if positional != nil {
+		log.Println("positional:", positional.Position, positional.Name)
+	}
+	return positional
+}
+
+// positionalAt returns the positional at the given position, or the last positional if it is variadic
+func (cmd *BceCommand) positionalAt(position int) *BceCommandPositional {
+	var last *BceCommandPositional
+	for i := range cmd.Positionals {
+		positional := &cmd.Positionals[i]
+		if positional.Position == position {
+			return positional
+		}
+		if (last == nil) || (positional.Position > last.Position) {
+			last = positional
+		}
+	}
+	if (last != nil) && (last.Arity == ArityVariadic) && (position > last.Position) {
+		return last
+	}
+	return nil
+}
+
+func (cmd *BceCommand) findSubCommand(word string) *BceCommand {
+	for i := range cmd.SubCommands {
+		subCmd := &cmd.SubCommands[i]
+		if subCmd.Name == word {
+			return subCmd
+		}
+		for _, alias := range subCmd.Aliases {
+			if alias.Name == word {
+				return subCmd
+			}
+		}
+	}
+	return nil
+}
+
+// findArg searches for the arg in the commands typed so far, innermost first
+func findArg(path []*BceCommand, word string) *BceCommandArg {
+	for i := len(path) - 1; i >= 0; i-- {
+		for j := range path[i].Args {
+			arg := &path[i].Args[j]
+			if (word == arg.LongName) || (word == arg.ShortName) {
+				return arg
+			}
+		}
+	}
+	return nil
+}
+
+// IsRequired checks if a value must be supplied for the positional
+func (positional *BceCommandPositional) IsRequired() bool {
+	return positional.Arity == ArityExactly
+}
+
+func (positional *BceCommandPositional) CollectRecommendations(input *BashInput) []BceRecommendation {
+	var results []BceRecommendation
+
+	switch positional.ArgType {
+	case ArgTypeText:
+		// free text, nothing to recommend
+	case ArgTypeFile:
+		results = CollectFileRecommendations(input, positional.FileFilter, false)
+	case ArgTypeDirectory:
+		results = CollectFileRecommendations(input, "", true)
+	case ArgTypeGenerator:
+		results = RunGenerator(positional.Generator, positional.GeneratorTTL, input)
+	default:
+		for _, opt := range positional.Opts {
+			if _, ok := input.MatchCurrentWord(opt.Name); ok {
+				results = append(results, BceRecommendation{Name: opt.Name, Description: positional.Description})
+			}
+		}
+	}
+	return results
+}
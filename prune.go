@@ -3,8 +3,8 @@ package main
 import "log"
 
 func (cmd *BceCommand) prune(input *BashInput) {
-	// build the list of words from the command CmdLine
-	words := BashInputToList(input.CmdLine, BashMaxLineSize)
+	// build the list of words from the command CmdLine (ignoring the partial word being completed)
+	words := input.CompletedWords()
 
 	cmd.pruneArguments(words)
 	cmd.pruneSubCommands(words)
@@ -94,7 +94,10 @@ func (cmd *BceCommand) pruneArguments(words []string) {
 			cmd.Args[i] = arg
 			// check if arg has options
 			shouldRemoveArg := false
-			if len(arg.Opts) == 0 {
+			if (arg.ArgType != ArgTypeNone) && arg.isLastWord(words) {
+				// keep the arg while its value is being completed
+				shouldRemoveArg = false
+			} else if len(arg.Opts) == 0 {
 				shouldRemoveArg = true
 			} else {
 				// possibly remove the arg, if an option has already been supplied
@@ -128,65 +131,88 @@ func (cmd *BceCommand) pruneArguments(words []string) {
 	}
 }
 
-func (cmd *BceCommand) CollectRequiredRecommendations(input *BashInput) []string {
-	var results []string
+// isLastWord checks if the arg is the last completed word (i.e. the word at the cursor is its value)
+func (arg *BceCommandArg) isLastWord(words []string) bool {
+	if len(words) == 0 {
+		return false
+	}
+	lastWord := words[len(words)-1]
+	return (len(lastWord) > 0) && ((lastWord == arg.LongName) || (lastWord == arg.ShortName))
+}
+
+type BceRecommendation struct {
+	Name        string
+	Alias       string
+	Description string
+}
+
+// String returns the recommendation decorated with its shortest alias, for display purposes
+func (rec BceRecommendation) String() string {
+	if len(rec.Alias) > 0 {
+		return rec.Name + " (" + rec.Alias + ")"
+	}
+	return rec.Name
+}
 
-	// if a current argument is selected, its options should be displayed 1st
-	arg := cmd.GetCurrentArg(*input.CurrentWord)
+func (cmd *BceCommand) CollectRequiredRecommendations(input *BashInput) []BceRecommendation {
+	var results []BceRecommendation
+
+	// if the previous word selected an argument, its options should be displayed 1st
+	if input.PreviousWord == nil {
+		return results
+	}
+	arg := cmd.GetCurrentArg(*input.PreviousWord)
 	if arg == nil {
 		return results
 	}
 
-	// if ArgType is NONE, don't expect options
-	if arg.ArgType != "NONE" {
+	switch arg.ArgType {
+	case ArgTypeNone:
+		// if ArgType is NONE, don't expect options
+	case ArgTypeFile:
+		results = CollectFileRecommendations(input, arg.FileFilter, false)
+	case ArgTypeDirectory:
+		results = CollectFileRecommendations(input, "", true)
+	case ArgTypeGenerator:
+		results = RunGenerator(arg.Generator, arg.GeneratorTTL, input)
+	default:
 		for _, opt := range arg.Opts {
-			results = append(results, opt.Name)
+			if _, ok := input.MatchCurrentWord(opt.Name); ok {
+				results = append(results, BceRecommendation{Name: opt.Name})
+			}
 		}
 	}
 	return results
 }
 
-func (cmd *BceCommand) CollectOptionalRecommendations(input *BashInput) []string {
-	var results []string
+func (cmd *BceCommand) CollectOptionalRecommendations(input *BashInput) []BceRecommendation {
+	var results []BceRecommendation
 
 	// collect all sub-cmds
 	for _, subCmd := range cmd.SubCommands {
 		if !subCmd.IsPresentOnCmdLine {
-			var recommendation string = subCmd.Name
-			if len(subCmd.Aliases) > 0 {
-				var shortest string
-				for _, alias := range subCmd.Aliases {
-					if len(shortest) == 0 {
-						shortest = alias.Name
-					} else if len(alias.Name) < len(shortest) {
-						shortest = alias.Name
-					}
-				}
-				recommendation = recommendation + " (" + shortest + ")"
+			if recommendation, ok := subCmd.recommend(input); ok {
+				results = append(results, recommendation)
 			}
-			results = append(results, recommendation)
+		} else {
+			// only descend into the sub-cmds which have been typed
+			subResults := subCmd.CollectOptionalRecommendations(input)
+			results = append(results, subResults...)
 		}
-		subResults := subCmd.CollectOptionalRecommendations(input)
-		results = append(results, subResults...)
 	}
 
 	// collect all the Args
 	for _, arg := range cmd.Args {
 		if !arg.IsPresentOnCmdLine {
-			var recommendation string
-			if len(arg.LongName) > 0 {
-				recommendation = arg.LongName
-				if len(arg.ShortName) > 0 {
-					recommendation = recommendation + " (" + arg.ShortName + ")"
-				}
-			} else {
-				recommendation = arg.ShortName
+			if recommendation, ok := arg.recommend(input); ok {
+				results = append(results, recommendation)
 			}
-			results = append(results, recommendation)
 		} else {
 			// collect all the options
 			for _, opt := range arg.Opts {
-				results = append(results, opt.Name)
+				if _, ok := input.MatchCurrentWord(opt.Name); ok {
+					results = append(results, BceRecommendation{Name: opt.Name})
+				}
 			}
 		}
 	}
@@ -194,6 +220,41 @@ func (cmd *BceCommand) CollectOptionalRecommendations(input *BashInput) []string
 	return results
 }
 
+// recommend builds the recommendation for a sub-cmd, if its name or one of its aliases matches the current word.
+// When only an alias matches, the alias is recommended, so the completion keeps what has been typed.
+func (cmd *BceCommand) recommend(input *BashInput) (BceRecommendation, bool) {
+	if _, ok := input.MatchCurrentWord(cmd.Name); ok {
+		var recommendation = BceRecommendation{Name: cmd.Name}
+		for _, alias := range cmd.Aliases {
+			if len(recommendation.Alias) == 0 {
+				recommendation.Alias = alias.Name
+			} else if len(alias.Name) < len(recommendation.Alias) {
+				recommendation.Alias = alias.Name
+			}
+		}
+		return recommendation, true
+	}
+	for _, alias := range cmd.Aliases {
+		if _, ok := input.MatchCurrentWord(alias.Name); ok {
+			return BceRecommendation{Name: alias.Name}, true
+		}
+	}
+	return BceRecommendation{}, false
+}
+
+// recommend builds the recommendation for an arg, if its long or short name matches the current word
+func (arg *BceCommandArg) recommend(input *BashInput) (BceRecommendation, bool) {
+	name, ok := input.MatchCurrentWord(arg.LongName, arg.ShortName)
+	if !ok {
+		return BceRecommendation{}, false
+	}
+	var recommendation = BceRecommendation{Name: name, Description: arg.Description}
+	if (name == arg.LongName) && (name != arg.ShortName) {
+		recommendation.Alias = arg.ShortName
+	}
+	return recommendation, true
+}
+
 func (cmd *BceCommand) GetCurrentArg(currentWord string) *BceCommandArg {
 	var foundArg *BceCommandArg = nil
 
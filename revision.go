@@ -0,0 +1,241 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// command_revision records each change to a root command (an import, merge, seed or rollback), with the command
+// as it was before (a JSON spec, NULL if it didn't exist), so the change can be rolled back.
+// It isn't tied to the command table, since the history outlives the command.
+const sqlCreateCommandRevision = `
+	CREATE TABLE IF NOT EXISTS command_revision (
+		id INTEGER PRIMARY KEY AUTOINCREMENT,
+		command_name TEXT NOT NULL,
+		created_at INTEGER NOT NULL,
+		author TEXT NOT NULL,
+		action TEXT NOT NULL,
+		source TEXT,
+		checksum TEXT,
+		previous_tree TEXT
+	);
+	CREATE INDEX command_revision_name_idx
+		ON command_revision (command_name, id);
+`
+
+const sqlWriteCommandRevision = `
+	INSERT INTO command_revision
+		(command_name, created_at, author, action, source, checksum, previous_tree)
+	VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?7)
+`
+
+const sqlReadCommandRevisions = `
+	SELECT id, command_name, created_at, author, action, IFNULL(source, ''), IFNULL(checksum, '')
+	FROM command_revision
+	WHERE command_name = ?1
+	ORDER BY id
+`
+
+const sqlReadCommandRevisionTree = `
+	SELECT previous_tree
+	FROM command_revision
+	WHERE id = ?1
+	AND command_name = ?2
+`
+
+// actions of a revision
+const (
+	RevisionImport   = "import"
+	RevisionMerge    = "merge"
+	RevisionSeed     = "seed"
+	RevisionRollback = "rollback"
+)
+
+// BceRevision is a recorded change to a root command
+type BceRevision struct {
+	Id        int64
+	Command   string
+	CreatedAt int64
+	Author    string
+	Action    string
+	// Source is the file (or URL) the change came from
+	Source   string
+	Checksum string
+}
+
+// checksum identifies the content of a source
+func checksum(data []byte) string {
+	hash := sha256.Sum256(data)
+	return "sha256:" + hex.EncodeToString(hash[:])
+}
+
+// sourcePath is the absolute path of a source file, as recorded in the history
+func sourcePath(filename string) string {
+	if path, err := filepath.Abs(filename); err == nil {
+		return path
+	}
+	return filename
+}
+
+// revisionAuthor is the user making a change
+func revisionAuthor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	if name := os.Getenv("USER"); len(name) > 0 {
+		return name
+	}
+	return "unknown"
+}
+
+// DBInsertRevision records a change to the command, given the command before the change (nil if it didn't exist)
+func DBInsertRevision(conn BceDBConn, cmdName string, action string, source string, sourceChecksum string, previous *BceCommand) error {
+	var previousTree *string
+	if previous != nil {
+		data, err := json.Marshal(BceCommandJsonWrapper{*previous})
+		if err != nil {
+			return err
+		}
+		tree := string(data)
+		previousTree = &tree
+	}
+
+	stmt, err := conn.Prepare(sqlWriteCommandRevision)
+	if err == nil {
+		defer stmt.Close()
+		_, err = stmt.Exec(cmdName, time.Now().Unix(), revisionAuthor(), action, source, sourceChecksum, previousTree)
+	}
+	return err
+}
+
+// DBQueryRevisions loads the history of a command, oldest first
+func DBQueryRevisions(conn *sql.DB, cmdName string) ([]BceRevision, error) {
+	rows, err := conn.Query(sqlReadCommandRevisions, cmdName)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var revisions []BceRevision
+	for rows.Next() {
+		var revision BceRevision
+		err = rows.Scan(&revision.Id, &revision.Command, &revision.CreatedAt, &revision.Author, &revision.Action, &revision.Source, &revision.Checksum)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, revision)
+	}
+	return revisions, rows.Err()
+}
+
+// DBRollbackCommand restores the command to how it was before the revision, in one transaction.
+// The rollback is itself recorded as a revision, so it can be rolled back too.
+func DBRollbackCommand(conn *sql.DB, cmdName string, revisionId int64) error {
+	dbConn, tx, err := DBBegin(conn)
+	if err != nil {
+		return err
+	}
+	defer dbConn.Close()
+
+	err = dbRollbackCommand(tx, cmdName, revisionId)
+	if err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func dbRollbackCommand(conn BceDBConn, cmdName string, revisionId int64) error {
+	var previousTree *string
+	err := conn.QueryRow(sqlReadCommandRevisionTree, revisionId, cmdName).Scan(&previousTree)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("no revision %d of %s (see --history %s)", revisionId, cmdName, cmdName)
+	}
+	if err != nil {
+		return err
+	}
+
+	var restored *BceCommand
+	if previousTree != nil {
+		restored, err = parseJsonCommand([]byte(*previousTree))
+		if err != nil {
+			return fmt.Errorf("revision %d of %s: %w", revisionId, cmdName, err)
+		}
+	}
+
+	current, err := DBQueryCommand(conn, cmdName)
+	if err != nil {
+		return err
+	}
+	if (current != nil) && (current.Name != cmdName) {
+		// an alias of another command
+		current = nil
+	}
+	err = DBInsertRevision(conn, cmdName, RevisionRollback, "revision "+strconv.FormatInt(revisionId, 10), "", current)
+	if err != nil {
+		return err
+	}
+
+	// the command didn't exist before the revision
+	if restored == nil {
+		return DBDeleteCommand(conn, cmdName)
+	}
+	return restored.ReplaceDB(conn)
+}
+
+// processHistory lists the revisions of a command in a layer
+func processHistory(cmdName string, layer string) error {
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	revisions, err := DBQueryRevisions(conn, cmdName)
+	if err != nil {
+		return err
+	}
+	if len(revisions) == 0 {
+		fmt.Println("No revisions of", cmdName)
+		return nil
+	}
+	for _, revision := range revisions {
+		fmt.Printf("%d\t%s\t%s\t%s\t%s\t%s\n", revision.Id, time.Unix(revision.CreatedAt, 0).Format("2006-01-02 15:04:05"),
+			revision.Author, revision.Action, revision.Source, revision.Checksum)
+	}
+	return nil
+}
+
+// processRollback restores a command of a layer to how it was before a revision (--revision, listed by --history).
+// Any other argument is refused: the flags after it wouldn't have been parsed, so --layer could be silently ignored.
+func processRollback(cmdName string, revisionId int64, args []string, layer string) error {
+	if len(args) > 0 {
+		return errors.New("unexpected arguments: " + strings.Join(args, " ") + " (give the revision with --revision, before any other argument)")
+	}
+	if revisionId <= 0 {
+		return fmt.Errorf("rollback requires a revision (--revision, see --history %s)", cmdName)
+	}
+
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	err = DBRollbackCommand(conn, cmdName, revisionId)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Rolled back", cmdName, "to before revision", revisionId)
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// the revision is a flag, and leftover arguments are refused, as the flags after them (--layer) weren't parsed
+func TestProcessRollback(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+	t.Setenv(DBSystemPathEnvVar, filepath.Join(dir, "system.db"))
+
+	conn, err := DBOpenLayer(DBLayerSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := &BceCommand{Uuid: "00000000-0000-0000-0000-00000000e001", Name: "tool"}
+	err = DBImportCommands(conn, []*BceCommand{cmd}, BceImportOptions{Source: "tool.json"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	revisions, err := DBQueryRevisions(conn, "tool")
+	DBClose(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 1 {
+		t.Fatalf("expected the import's revision, got %v", revisions)
+	}
+
+	if processRollback("tool", revisions[0].Id, []string{"1", "--layer", "system"}, DBLayerSystem) == nil {
+		t.Error("the leftover arguments were accepted")
+	}
+	if processRollback("tool", 0, nil, DBLayerSystem) == nil {
+		t.Error("a rollback without a revision was accepted")
+	}
+
+	err = processRollback("tool", revisions[0].Id, nil, DBLayerSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err = DBOpenLayer(DBLayerSystem)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer DBClose(conn)
+	restored, err := DBQueryCommand(conn, "tool")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restored != nil {
+		t.Error("the imported command is still there")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"strconv"
+)
+
+// SpecSchemaVersion versions the JSON Schema of the spec format, it changes whenever the importer accepts something else
+const SpecSchemaVersion = 1
+
+// the schema of a required string, which can't be blank, and of an optional one
+var (
+	schemaRequiredString = map[string]interface{}{"type": "string", "pattern": `\S`}
+	schemaOptionalString = map[string]interface{}{"type": []string{"string", "null"}}
+	schemaName           = map[string]interface{}{"type": "string", "pattern": `^\S+$`}
+	schemaUuid           = schemaOptionalString
+	schemaGeneratorTTL   = map[string]interface{}{"type": []string{"integer", "null"}, "minimum": -math.MaxInt32, "maximum": math.MaxInt32}
+)
+
+// schemaList is an optional list of a definition
+func schemaList(definition string) map[string]interface{} {
+	return map[string]interface{}{"type": []string{"array", "null"}, "items": schemaRef(definition)}
+}
+
+func schemaRef(definition string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/definitions/" + definition}
+}
+
+func schemaEnum(values []string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values}
+}
+
+// schemaObject describes an object with only the given attributes (those the decoder accepts), taking their schemas
+// from properties. An attribute without a schema is a programming error, caught by --export-schema.
+func schemaObject(description string, attributes []string, properties map[string]interface{}, required ...string) (map[string]interface{}, error) {
+	var objectProperties = map[string]interface{}{}
+	for _, attribute := range attributes {
+		property, ok := properties[attribute]
+		if !ok {
+			return nil, fmt.Errorf("no schema for the %s attribute %s", description, attribute)
+		}
+		objectProperties[attribute] = property
+	}
+	for attribute := range properties {
+		if !contains(attributes, attribute) {
+			return nil, fmt.Errorf("the %s attribute %s isn't accepted by the importer", description, attribute)
+		}
+	}
+	return map[string]interface{}{
+		"description":          description,
+		"type":                 "object",
+		"properties":           objectProperties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// SpecSchema builds the JSON Schema of the specs accepted by --import (a spec, or a bundle of specs as an array).
+// An NDJSON bundle (a spec per line) isn't a JSON document, each of its lines is validated as a spec.
+// The uniqueness rules (of names, aliases, long and short names, positions and uuids) can't be expressed, so
+// the schema accepts some specs which --validate rejects.
+func SpecSchema() (map[string]interface{}, error) {
+	definitions := map[string]interface{}{}
+	var err error
+
+	definitions["spec"], err = schemaObject("spec", specWrapperAttributes, map[string]interface{}{
+		"command": schemaRef("command"),
+	}, "command")
+	if err != nil {
+		return nil, err
+	}
+
+	definitions["command"], err = schemaObject("command", specCommandAttributes, map[string]interface{}{
+		"uuid":         schemaUuid,
+		"name":         schemaName,
+		"aliases":      schemaList("alias"),
+		"sub_commands": schemaList("command"),
+		"args":         schemaList("arg"),
+		"positionals":  schemaList("positional"),
+	}, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	definitions["alias"], err = schemaObject("alias", specAliasAttributes, map[string]interface{}{
+		"uuid": schemaUuid,
+		"name": schemaName,
+	}, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	arg, err := schemaObject("arg", specArgAttributes, map[string]interface{}{
+		"uuid":          schemaUuid,
+		"arg_type":      schemaEnum(ArgTypes),
+		"description":   schemaRequiredString,
+		"long_name":     schemaOptionalString,
+		"short_name":    schemaOptionalString,
+		"file_filter":   schemaOptionalString,
+		"generator":     schemaOptionalString,
+		"generator_ttl": schemaGeneratorTTL,
+		"opts":          schemaList("opt"),
+	}, "arg_type", "description")
+	if err != nil {
+		return nil, err
+	}
+	// an arg has a long or a short name
+	arg["anyOf"] = []interface{}{
+		map[string]interface{}{"required": []string{"long_name"}, "properties": map[string]interface{}{"long_name": map[string]interface{}{"minLength": 1}}},
+		map[string]interface{}{"required": []string{"short_name"}, "properties": map[string]interface{}{"short_name": map[string]interface{}{"minLength": 1}}},
+	}
+	arg["if"] = schemaGeneratorType()
+	arg["then"] = schemaGeneratorRequired()
+	definitions["arg"] = arg
+
+	positional, err := schemaObject("positional", specPositionalAttributes, map[string]interface{}{
+		"uuid":          schemaUuid,
+		"position":      map[string]interface{}{"type": "integer", "minimum": 1, "maximum": math.MaxInt32},
+		"name":          schemaRequiredString,
+		"arity":         map[string]interface{}{"description": "defaults to " + ArityExactly + " when empty or missing", "enum": append(stringsToInterfaces(Arities), "", nil)},
+		"arg_type":      schemaEnum(PositionalArgTypes),
+		"description":   schemaRequiredString,
+		"file_filter":   schemaOptionalString,
+		"generator":     schemaOptionalString,
+		"generator_ttl": schemaGeneratorTTL,
+		"opts":          schemaList("opt"),
+	}, "position", "name", "arg_type", "description")
+	if err != nil {
+		return nil, err
+	}
+	positional["if"] = schemaGeneratorType()
+	positional["then"] = schemaGeneratorRequired()
+	definitions["positional"] = positional
+
+	definitions["opt"], err = schemaObject("opt", specOptAttributes, map[string]interface{}{
+		"uuid": schemaUuid,
+		"name": schemaRequiredString,
+	}, "name")
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"$schema":     "http://json-schema.org/draft-07/schema#",
+		"$id":         "urn:bce:spec-schema:" + strconv.Itoa(SpecSchemaVersion),
+		"title":       "bce command spec, version " + strconv.Itoa(SpecSchemaVersion),
+		"description": "A command spec for --import, or a bundle of them as an array. Each line of an NDJSON bundle is validated on its own, as a spec. Names and aliases must also be unique among siblings, as checked by --validate.",
+		"oneOf": []interface{}{
+			schemaRef("spec"),
+			map[string]interface{}{"type": "array", "items": schemaRef("spec"), "minItems": 1},
+		},
+		"definitions": definitions,
+	}, nil
+}
+
+// a GENERATOR arg or positional needs its generator command
+func schemaGeneratorType() map[string]interface{} {
+	return map[string]interface{}{"required": []string{"arg_type"}, "properties": map[string]interface{}{"arg_type": map[string]interface{}{"const": ArgTypeGenerator}}}
+}
+
+func schemaGeneratorRequired() map[string]interface{} {
+	return map[string]interface{}{"required": []string{"generator"}, "properties": map[string]interface{}{"generator": schemaRequiredString}}
+}
+
+func stringsToInterfaces(values []string) []interface{} {
+	var result []interface{}
+	for _, value := range values {
+		result = append(result, value)
+	}
+	return result
+}
+
+// processExportSchema writes the JSON Schema of the spec format to the file, or stdout
+func processExportSchema(filename string) error {
+	schema, err := SpecSchema()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if len(filename) == 0 {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	return ioutil.WriteFile(filename, data, 0644)
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// testdata/specs holds specs as --export writes them (so importing and exporting one gives it back), which the schema
+// and the importer must both accept, testdata/specs/accepted specs which they must both accept too (but which export
+// differently, with the defaults filled in), and testdata/specs/invalid specs which they must both reject: a fixture
+// for each rule of the decoder which the schema expresses.
+
+// testdata/spec-schema.json is the schema --export-schema writes, go test -run TestSpecSchemaGolden -update rewrites it
+const specSchemaGolden = "testdata/spec-schema.json"
+
+// loadSpecSchema is SpecSchema as its JSON document, as an editor reads it
+func loadSpecSchema(t *testing.T) map[string]interface{} {
+	t.Helper()
+	schema, err := SpecSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document map[string]interface{}
+	err = json.Unmarshal(data, &document)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return document
+}
+
+// validateSchema checks a decoded JSON value against a schema, returning the problems. Only the keywords of
+// JSON Schema draft-07 used by SpecSchema are supported, an unknown keyword is a problem.
+func validateSchema(root map[string]interface{}, schema map[string]interface{}, path string, value interface{}) []string {
+	var problems []string
+	object, isObject := value.(map[string]interface{})
+	array, isArray := value.([]interface{})
+	text, isString := value.(string)
+	number, isNumber := value.(float64)
+
+	for keyword, constraint := range schema {
+		switch keyword {
+		case "$schema", "$id", "title", "description", "definitions", "then":
+		case "$ref":
+			name := strings.TrimPrefix(constraint.(string), "#/definitions/")
+			definition := root["definitions"].(map[string]interface{})[name].(map[string]interface{})
+			problems = append(problems, validateSchema(root, definition, path, value)...)
+		case "type":
+			types, ok := constraint.([]interface{})
+			if !ok {
+				types = []interface{}{constraint}
+			}
+			var matched bool
+			for _, kind := range types {
+				matched = matched || schemaTypeMatches(kind.(string), value)
+			}
+			if !matched {
+				problems = append(problems, fmt.Sprintf("%s: is not of type %v", path, constraint))
+			}
+		case "enum":
+			var matched bool
+			for _, allowed := range constraint.([]interface{}) {
+				matched = matched || reflect.DeepEqual(allowed, value)
+			}
+			if !matched {
+				problems = append(problems, fmt.Sprintf("%s: %v is not one of %v", path, value, constraint))
+			}
+		case "const":
+			if !reflect.DeepEqual(constraint, value) {
+				problems = append(problems, fmt.Sprintf("%s: %v is not %v", path, value, constraint))
+			}
+		case "pattern":
+			if isString && !regexp.MustCompile(constraint.(string)).MatchString(text) {
+				problems = append(problems, fmt.Sprintf("%s: %q doesn't match %s", path, text, constraint))
+			}
+		case "minLength":
+			if isString && (float64(utf8.RuneCountInString(text)) < constraint.(float64)) {
+				problems = append(problems, fmt.Sprintf("%s: %q is too short", path, text))
+			}
+		case "minimum":
+			if isNumber && (number < constraint.(float64)) {
+				problems = append(problems, fmt.Sprintf("%s: %v is less than %v", path, number, constraint))
+			}
+		case "maximum":
+			if isNumber && (number > constraint.(float64)) {
+				problems = append(problems, fmt.Sprintf("%s: %v is more than %v", path, number, constraint))
+			}
+		case "properties":
+			for name, property := range constraint.(map[string]interface{}) {
+				if attribute, ok := object[name]; isObject && ok {
+					problems = append(problems, validateSchema(root, property.(map[string]interface{}), path+"."+name, attribute)...)
+				}
+			}
+		case "required":
+			for _, name := range constraint.([]interface{}) {
+				if _, ok := object[name.(string)]; isObject && !ok {
+					problems = append(problems, fmt.Sprintf("%s: %s is required", path, name))
+				}
+			}
+		case "additionalProperties":
+			properties, _ := schema["properties"].(map[string]interface{})
+			for name := range object {
+				if _, ok := properties[name]; !ok && (constraint == false) {
+					problems = append(problems, fmt.Sprintf("%s: %s is not allowed", path, name))
+				}
+			}
+		case "items":
+			for i, item := range array {
+				problems = append(problems, validateSchema(root, constraint.(map[string]interface{}), fmt.Sprintf("%s[%d]", path, i), item)...)
+			}
+		case "minItems":
+			if isArray && (float64(len(array)) < constraint.(float64)) {
+				problems = append(problems, fmt.Sprintf("%s: has fewer than %v items", path, constraint))
+			}
+		case "oneOf", "anyOf":
+			var matches int
+			var alternatives []string
+			for _, alternative := range constraint.([]interface{}) {
+				alternativeProblems := validateSchema(root, alternative.(map[string]interface{}), path, value)
+				if len(alternativeProblems) == 0 {
+					matches++
+				}
+				alternatives = append(alternatives, alternativeProblems...)
+			}
+			if (matches == 0) || ((keyword == "oneOf") && (matches > 1)) {
+				problems = append(problems, fmt.Sprintf("%s: matches %d of the %s schemas (%s)", path, matches, keyword, strings.Join(alternatives, "; ")))
+			}
+		case "if":
+			if len(validateSchema(root, constraint.(map[string]interface{}), path, value)) == 0 {
+				problems = append(problems, validateSchema(root, schema["then"].(map[string]interface{}), path, value)...)
+			}
+		default:
+			problems = append(problems, fmt.Sprintf("%s: the keyword %s isn't supported by the test", path, keyword))
+		}
+	}
+	return problems
+}
+
+func schemaTypeMatches(kind string, value interface{}) bool {
+	switch kind {
+	case "null":
+		return value == nil
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "integer":
+		number, ok := value.(float64)
+		return ok && (number == math.Trunc(number))
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	}
+	return false
+}
+
+// importExportSpecs imports the specs into a new user database, then exports them as --export does
+func importExportSpecs(t *testing.T, data []byte) []byte {
+	t.Helper()
+	cmds, err := parseSpecBundle(data, FormatJson)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a database which isn't seeded with the embedded specs
+	dir := t.TempDir()
+	t.Setenv(DBPathEnvVar, filepath.Join(dir, "user.db"))
+	t.Setenv(DBSystemPathEnvVar, filepath.Join(dir, "system.db"))
+	conn, err := DBOpen(filepath.Join(dir, "user.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = DBEnsureSchema(conn)
+	if err == nil {
+		err = DBImportCommands(conn, cmds, BceImportOptions{})
+	}
+	DBClose(conn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var exported []*BceCommand
+	for _, cmd := range cmds {
+		layerCmds, err := queryExportCommands(DBLayerUser, cmd.Name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		exported = append(exported, layerCmds...)
+	}
+	bundle := bytes.HasPrefix(bytes.TrimSpace(data), []byte("["))
+	output, err := marshalSpecs(exported, FormatJson, bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return append(output, '\n')
+}
+
+func TestSpecFixtures(t *testing.T) {
+	schema := loadSpecSchema(t)
+	filenames, err := filepath.Glob(filepath.Join("testdata", "specs", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		t.Fatal("no spec fixtures")
+	}
+
+	for _, filename := range filenames {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var spec interface{}
+			err = json.Unmarshal(data, &spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range validateSchema(schema, schema, "", spec) {
+				t.Error("schema:", problem)
+			}
+
+			exported := importExportSpecs(t, data)
+			if !bytes.Equal(exported, data) {
+				t.Errorf("the export differs from %s:\n%s", filename, firstDifference(string(data), string(exported)))
+			}
+		})
+	}
+}
+
+// the embedded specs, which seed a new database, follow the schema too
+func TestEmbeddedSpecsSchema(t *testing.T) {
+	schema := loadSpecSchema(t)
+	filenames, err := filepath.Glob(filepath.Join(embeddedSpecsDir, "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var spec interface{}
+		err = json.Unmarshal(data, &spec)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, problem := range validateSchema(schema, schema, "", spec) {
+			t.Errorf("%s: %s", filename, problem)
+		}
+	}
+}
+
+func TestInvalidSpecFixtures(t *testing.T) {
+	schema := loadSpecSchema(t)
+	filenames, err := filepath.Glob(filepath.Join("testdata", "specs", "invalid", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		t.Fatal("no invalid spec fixtures")
+	}
+
+	for _, filename := range filenames {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var spec interface{}
+			err = json.Unmarshal(data, &spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(validateSchema(schema, schema, "", spec)) == 0 {
+				t.Error("the schema accepts it")
+			}
+			if _, err := parseSpecBundle(data, FormatJson); err == nil {
+				t.Error("the importer accepts it")
+			}
+		})
+	}
+}
+
+func TestAcceptedSpecFixtures(t *testing.T) {
+	schema := loadSpecSchema(t)
+	filenames, err := filepath.Glob(filepath.Join("testdata", "specs", "accepted", "*.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filenames) == 0 {
+		t.Fatal("no accepted spec fixtures")
+	}
+
+	for _, filename := range filenames {
+		t.Run(filepath.Base(filename), func(t *testing.T) {
+			data, err := ioutil.ReadFile(filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var spec interface{}
+			err = json.Unmarshal(data, &spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, problem := range validateSchema(schema, schema, "", spec) {
+				t.Error("schema:", problem)
+			}
+			if _, err := parseSpecBundle(data, FormatJson); err != nil {
+				t.Error("importer:", err)
+			}
+		})
+	}
+}
+
+// the schema only changes on purpose, as editors validate specs against the published one
+func TestSpecSchemaGolden(t *testing.T) {
+	if *updateGolden {
+		err := processExportSchema(specSchemaGolden)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	expected, err := ioutil.ReadFile(specSchemaGolden)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join(t.TempDir(), "spec-schema.json")
+	err = processExportSchema(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(schema, expected) {
+		t.Errorf("the schema differs from %s (go test -update to accept it, and bump SpecSchemaVersion if the importer accepts something else):\n%s",
+			specSchemaGolden, firstDifference(string(expected), string(schema)))
+	}
+}
@@ -0,0 +1,558 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+)
+
+// scriptFormats are the --export formats which compile a command into a standalone completion script
+var scriptFormats = []string{"bash", "zsh", "fish", "powershell"}
+
+// scriptNode flattens a node of the command tree for the script generators
+type scriptNode struct {
+	Path []string
+	Cmd  *BceCommand
+	// the args of the command and all of its ancestors, which remain valid below a sub-command
+	Args []BceCommandArg
+}
+
+var nonIdentifierChars = regexp.MustCompile(`[^A-Za-z0-9_]`)
+
+func isScriptFormat(format string) bool {
+	return contains(scriptFormats, format)
+}
+
+func processExportScript(commandName string, format string, filename string, layer string) error {
+	// load the command hierarchy
+	cmd, err := DBQueryCommandFromLayer(layer, commandName)
+	if err != nil {
+		return err
+	}
+
+	err = checkScriptable(cmd)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "bash":
+		writeBashScript(&buf, cmd)
+	case "zsh":
+		writeZshScript(&buf, cmd)
+	case "fish":
+		writeFishScript(&buf, cmd)
+	case "powershell":
+		writePowerShellScript(&buf, cmd)
+	default:
+		return errors.New("unsupported script format: " + format)
+	}
+
+	return ioutil.WriteFile(filename, buf.Bytes(), 0644)
+}
+
+// checkScriptable refuses a command with positionals or GENERATOR args, which the scripts can't complete:
+// a script completing less than bce itself would be mistaken for a complete one
+func checkScriptable(cmd *BceCommand) error {
+	var problems []string
+	for _, node := range collectScriptNodes(cmd, nil) {
+		path := strings.Join(node.Path, " ")
+		for _, arg := range node.Cmd.Args {
+			if arg.ArgType == ArgTypeGenerator {
+				problems = append(problems, path+" "+argLabel(arg)+": is a GENERATOR arg")
+			}
+		}
+		for _, positional := range node.Cmd.Positionals {
+			problems = append(problems, path+": has the positional "+positional.Name)
+		}
+	}
+	if len(problems) > 0 {
+		return errors.New("positionals and GENERATOR args can't be exported to a script, register bce itself (--init) instead\n" +
+			strings.Join(problems, "\n"))
+	}
+	return nil
+}
+
+// collectScriptNodes walks the command tree (depth-first), collecting every command with its inherited args
+func collectScriptNodes(cmd *BceCommand, parent *scriptNode) []scriptNode {
+	var node scriptNode
+	if parent == nil {
+		node.Path = []string{cmd.Name}
+	} else {
+		node.Path = append(append([]string{}, parent.Path...), cmd.Name)
+		node.Args = append(node.Args, parent.Args...)
+	}
+	node.Cmd = cmd
+	node.Args = append(node.Args, cmd.Args...)
+
+	nodes := []scriptNode{node}
+	for i := range cmd.SubCommands {
+		nodes = append(nodes, collectScriptNodes(&cmd.SubCommands[i], &node)...)
+	}
+	return nodes
+}
+
+// commandNames returns the name of the command followed by its aliases
+func (cmd *BceCommand) commandNames() []string {
+	names := []string{cmd.Name}
+	for _, alias := range cmd.Aliases {
+		names = append(names, alias.Name)
+	}
+	return names
+}
+
+// argNames returns the long and short names of the arg which have been defined
+func (arg *BceCommandArg) argNames() []string {
+	var names []string
+	if len(arg.LongName) > 0 {
+		names = append(names, arg.LongName)
+	}
+	if len(arg.ShortName) > 0 {
+		names = append(names, arg.ShortName)
+	}
+	return names
+}
+
+func (arg *BceCommandArg) optNames() []string {
+	var names []string
+	for _, opt := range arg.Opts {
+		names = append(names, opt.Name)
+	}
+	return names
+}
+
+func (arg *BceCommandArg) fileFilterPatterns() []string {
+	var patterns []string
+	for _, pattern := range strings.Split(arg.FileFilter, FileFilterSeparator) {
+		pattern = strings.TrimSpace(pattern)
+		if len(pattern) > 0 {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+func scriptFunctionName(path []string) string {
+	return "_" + nonIdentifierChars.ReplaceAllString(strings.Join(path, "_"), "_")
+}
+
+func writeBashScript(w io.Writer, cmd *BceCommand) {
+	nodes := collectScriptNodes(cmd, nil)
+	funcName := "_bce" + scriptFunctionName([]string{cmd.Name})
+
+	fmt.Fprintf(w, "# bash completion for %s (generated by bce)\n\n", cmd.Name)
+	fmt.Fprintf(w, "%s() {\n", funcName)
+	fmt.Fprintln(w, `    local cur="${COMP_WORDS[COMP_CWORD]}"`)
+	fmt.Fprintln(w, `    local prev="${COMP_WORDS[COMP_CWORD-1]}"`)
+	fmt.Fprintf(w, "    local path=%s\n", shellQuote(cmd.Name))
+	fmt.Fprintln(w, "    local i")
+	fmt.Fprintln(w)
+
+	// find the deepest sub-command on the line
+	fmt.Fprintln(w, "    for ((i = 1; i < COMP_CWORD; i++)); do")
+	fmt.Fprintln(w, `        case "${path}:${COMP_WORDS[i]}" in`)
+	for _, node := range nodes[1:] {
+		parentPath := strings.Join(node.Path[:len(node.Path)-1], "/")
+		var patterns []string
+		for _, name := range node.Cmd.commandNames() {
+			patterns = append(patterns, shellQuote(parentPath+":"+name))
+		}
+		fmt.Fprintf(w, "            %s) path=%s ;;\n", strings.Join(patterns, "|"), shellQuote(strings.Join(node.Path, "/")))
+	}
+	fmt.Fprintln(w, "        esac")
+	fmt.Fprintln(w, "    done")
+	fmt.Fprintln(w)
+
+	// complete the value of the previous arg
+	fmt.Fprintln(w, `    case "${path}:${prev}" in`)
+	for _, node := range nodes {
+		path := strings.Join(node.Path, "/")
+		for _, arg := range node.Args {
+			if arg.ArgType == ArgTypeNone {
+				continue
+			}
+			var patterns []string
+			for _, name := range arg.argNames() {
+				patterns = append(patterns, shellQuote(path+":"+name))
+			}
+			fmt.Fprintf(w, "        %s)\n", strings.Join(patterns, "|"))
+			switch arg.ArgType {
+			case ArgTypeFile:
+				fmt.Fprintln(w, "            compopt -o filenames 2>/dev/null")
+				filters := arg.fileFilterPatterns()
+				if len(filters) == 0 {
+					fmt.Fprintln(w, `            COMPREPLY=($(compgen -f -- "$cur"))`)
+				} else {
+					fmt.Fprint(w, `            COMPREPLY=($(compgen -d -- "$cur")`)
+					for _, filter := range filters {
+						fmt.Fprintf(w, ` $(compgen -f -X %s -- "$cur")`, shellQuote("!"+filter))
+					}
+					fmt.Fprintln(w, ")")
+				}
+			case ArgTypeDirectory:
+				fmt.Fprintln(w, "            compopt -o filenames 2>/dev/null")
+				fmt.Fprintln(w, `            COMPREPLY=($(compgen -d -- "$cur"))`)
+			case ArgTypeOption:
+				fmt.Fprintf(w, "            COMPREPLY=($(compgen -W %s -- \"$cur\"))\n", shellQuote(strings.Join(arg.optNames(), " ")))
+			default:
+				// free text, nothing to recommend
+				fmt.Fprintln(w, "            COMPREPLY=()")
+			}
+			fmt.Fprintln(w, "            return ;;")
+		}
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w)
+
+	// complete the sub-commands and args
+	fmt.Fprintln(w, `    case "$path" in`)
+	for _, node := range nodes {
+		var words []string
+		for _, subCmd := range node.Cmd.SubCommands {
+			words = append(words, subCmd.commandNames()...)
+		}
+		for _, arg := range node.Args {
+			words = append(words, arg.argNames()...)
+		}
+		fmt.Fprintf(w, "        %s)\n", shellQuote(strings.Join(node.Path, "/")))
+		fmt.Fprintf(w, "            COMPREPLY=($(compgen -W %s -- \"$cur\")) ;;\n", shellQuote(strings.Join(words, " ")))
+	}
+	fmt.Fprintln(w, "    esac")
+	fmt.Fprintln(w, "}")
+	fmt.Fprintln(w)
+
+	fmt.Fprint(w, "complete -F ", funcName)
+	for _, name := range cmd.commandNames() {
+		fmt.Fprint(w, " ", shellQuote(name))
+	}
+	fmt.Fprintln(w)
+}
+
+// zshEscape escapes the characters with special meaning in an _arguments spec or _describe item
+func zshEscape(value string) string {
+	var replacer = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`, `:`, `\:`)
+	return replacer.Replace(singleLine(value))
+}
+
+// zshArgSpec builds the _arguments spec for an arg, e.g. '(--output -o)'{--output,-o}'[Output format]:output:(json yaml)'
+func zshArgSpec(arg *BceCommandArg) string {
+	var action string
+	switch arg.ArgType {
+	case ArgTypeNone:
+		action = ""
+	case ArgTypeOption:
+		var opts []string
+		for _, opt := range arg.optNames() {
+			opts = append(opts, strings.NewReplacer(`\`, `\\`, ` `, `\ `, `(`, `\(`, `)`, `\)`, `:`, `\:`).Replace(opt))
+		}
+		action = ":value:(" + strings.Join(opts, " ") + ")"
+	case ArgTypeFile:
+		filters := arg.fileFilterPatterns()
+		if len(filters) == 0 {
+			action = ":file:_files"
+		} else {
+			action = ":file:_files -g \"" + strings.Join(filters, " ") + "\""
+		}
+	case ArgTypeDirectory:
+		action = ":directory:_files -/"
+	default:
+		action = ":value: "
+	}
+	description := "[" + zshEscape(arg.Description) + "]" + action
+
+	names := arg.argNames()
+	if len(names) == 1 {
+		return shellQuote(names[0] + description)
+	}
+	return shellQuote("("+strings.Join(names, " ")+")") + "{" + strings.Join(names, ",") + "}" + shellQuote(description)
+}
+
+func writeZshScript(w io.Writer, cmd *BceCommand) {
+	nodes := collectScriptNodes(cmd, nil)
+	rootFuncName := scriptFunctionName(nodes[0].Path)
+
+	fmt.Fprintf(w, "#compdef %s\n", strings.Join(cmd.commandNames(), " "))
+	fmt.Fprintf(w, "# zsh completion for %s (generated by bce)\n", cmd.Name)
+
+	for _, node := range nodes {
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "%s() {\n", scriptFunctionName(node.Path))
+
+		var specs []string
+		for i := range node.Args {
+			specs = append(specs, zshArgSpec(&node.Args[i]))
+		}
+		hasSubCmds := len(node.Cmd.SubCommands) > 0
+		if hasSubCmds {
+			specs = append(specs, `'1: :->cmds'`, `'*:: :->args'`)
+			fmt.Fprintln(w, `    local curcontext="$curcontext" state line`)
+			fmt.Fprintln(w, "    typeset -A opt_args")
+			fmt.Fprintln(w)
+		}
+		if len(specs) == 0 {
+			fmt.Fprintln(w, "    _message 'no more arguments'")
+			fmt.Fprintln(w, "}")
+			continue
+		}
+
+		if hasSubCmds {
+			fmt.Fprint(w, "    _arguments -C")
+		} else {
+			fmt.Fprint(w, "    _arguments")
+		}
+		for _, spec := range specs {
+			fmt.Fprint(w, " \\\n        ", spec)
+		}
+		fmt.Fprintln(w)
+
+		if hasSubCmds {
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "    case $state in")
+			fmt.Fprintln(w, "        cmds)")
+			fmt.Fprintln(w, "            local -a subcmds")
+			fmt.Fprintln(w, "            subcmds=(")
+			for _, subCmd := range node.Cmd.SubCommands {
+				fmt.Fprintf(w, "                %s\n", shellQuote(zshEscape(subCmd.Name)))
+				for _, alias := range subCmd.Aliases {
+					fmt.Fprintf(w, "                %s\n", shellQuote(zshEscape(alias.Name)+":alias of "+zshEscape(subCmd.Name)))
+				}
+			}
+			fmt.Fprintln(w, "            )")
+			fmt.Fprintf(w, "            _describe -t commands %s subcmds\n", shellQuote(strings.Join(node.Path, " ")+" command"))
+			fmt.Fprintln(w, "            ;;")
+			fmt.Fprintln(w, "        args)")
+			fmt.Fprintln(w, "            case $line[1] in")
+			for _, subCmd := range node.Cmd.SubCommands {
+				var patterns []string
+				for _, name := range subCmd.commandNames() {
+					patterns = append(patterns, shellQuote(name))
+				}
+				subPath := append(append([]string{}, node.Path...), subCmd.Name)
+				fmt.Fprintf(w, "                %s)\n", strings.Join(patterns, "|"))
+				fmt.Fprintf(w, "                    %s\n", scriptFunctionName(subPath))
+				fmt.Fprintln(w, "                    ;;")
+			}
+			fmt.Fprintln(w, "            esac")
+			fmt.Fprintln(w, "            ;;")
+			fmt.Fprintln(w, "    esac")
+		}
+		fmt.Fprintln(w, "}")
+	}
+
+	// support both autoloading from $fpath and sourcing the file
+	fmt.Fprintln(w)
+	fmt.Fprintf(w, "if [ \"$funcstack[1]\" = \"%s\" ]; then\n", rootFuncName)
+	fmt.Fprintf(w, "    %s \"$@\"\n", rootFuncName)
+	fmt.Fprintln(w, "else")
+	fmt.Fprint(w, "    compdef ", rootFuncName)
+	for _, name := range cmd.commandNames() {
+		fmt.Fprint(w, " ", shellQuote(name))
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "fi")
+}
+
+// fishArgOptions maps the names of an arg onto the long (-l), short (-s) and old-style (-o) options of `complete`
+func fishArgOptions(arg *BceCommandArg) string {
+	var options []string
+	for _, name := range arg.argNames() {
+		if strings.HasPrefix(name, "--") {
+			options = append(options, "-l "+fishQuote(name[2:]))
+		} else if strings.HasPrefix(name, "-") && (len(name) == 2) {
+			options = append(options, "-s "+fishQuote(name[1:]))
+		} else {
+			options = append(options, "-o "+fishQuote(strings.TrimPrefix(name, "-")))
+		}
+	}
+	return strings.Join(options, " ")
+}
+
+func writeFishScript(w io.Writer, cmd *BceCommand) {
+	nodes := collectScriptNodes(cmd, nil)
+	name := fishQuote(cmd.Name)
+
+	fmt.Fprintf(w, "# fish completion for %s (generated by bce)\n\n", cmd.Name)
+	fmt.Fprintf(w, "complete -c %s -f\n", name)
+
+	for _, node := range nodes {
+		// the node is selected once each sub-command in its path (or an alias) has been seen
+		var conditions []string
+		cmdPath := []*BceCommand{cmd}
+		for _, subName := range node.Path[1:] {
+			parent := cmdPath[len(cmdPath)-1]
+			for i := range parent.SubCommands {
+				if parent.SubCommands[i].Name == subName {
+					cmdPath = append(cmdPath, &parent.SubCommands[i])
+					break
+				}
+			}
+		}
+		for _, pathCmd := range cmdPath[1:] {
+			conditions = append(conditions, "__fish_seen_subcommand_from "+strings.Join(pathCmd.commandNames(), " "))
+		}
+
+		if (len(node.Cmd.SubCommands) == 0) && (len(node.Cmd.Args) == 0) {
+			continue
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintf(w, "# %s\n", strings.Join(node.Path, " "))
+
+		if len(node.Cmd.SubCommands) > 0 {
+			var childNames []string
+			for _, subCmd := range node.Cmd.SubCommands {
+				childNames = append(childNames, subCmd.commandNames()...)
+			}
+			subConditions := append(append([]string{}, conditions...), "not __fish_seen_subcommand_from "+strings.Join(childNames, " "))
+			condition := fishQuote(strings.Join(subConditions, "; and "))
+			for _, subCmd := range node.Cmd.SubCommands {
+				fmt.Fprintf(w, "complete -c %s -n %s -a %s\n", name, condition, fishQuote(subCmd.Name))
+				for _, alias := range subCmd.Aliases {
+					fmt.Fprintf(w, "complete -c %s -n %s -a %s -d %s\n", name, condition, fishQuote(alias.Name), fishQuote("alias of "+subCmd.Name))
+				}
+			}
+		}
+
+		var nodeCondition string
+		if len(conditions) > 0 {
+			nodeCondition = " -n " + fishQuote(strings.Join(conditions, "; and "))
+		}
+		// inherited args are already registered under the ancestor's (less specific) condition
+		for _, arg := range node.Cmd.Args {
+			fmt.Fprintf(w, "complete -c %s%s %s -d %s", name, nodeCondition, fishArgOptions(&arg), fishQuote(singleLine(arg.Description)))
+			switch arg.ArgType {
+			case ArgTypeOption:
+				fmt.Fprintf(w, " -x -a %s", fishQuote(strings.Join(arg.optNames(), " ")))
+			case ArgTypeFile:
+				fmt.Fprint(w, " -r -F")
+			case ArgTypeDirectory:
+				fmt.Fprint(w, " -x -a '(__fish_complete_directories)'")
+			case ArgTypeText:
+				fmt.Fprint(w, " -x")
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	// aliases of the root command share its completions
+	if len(cmd.Aliases) > 0 {
+		fmt.Fprintln(w)
+		for _, alias := range cmd.Aliases {
+			fmt.Fprintf(w, "complete -c %s -w %s\n", fishQuote(alias.Name), name)
+		}
+	}
+}
+
+// psQuote wraps a value in a PowerShell single-quoted (verbatim) string
+func psQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", "''") + "'"
+}
+
+func psQuoteList(values []string) string {
+	var quoted []string
+	for _, value := range values {
+		quoted = append(quoted, psQuote(value))
+	}
+	return "@(" + strings.Join(quoted, ", ") + ")"
+}
+
+// powerShellCompleter is the body of the completer, driven by the $commands table generated ahead of it
+const powerShellCompleter = `
+    # walk the completed words, to find the current sub-command and the previous word
+    $path = %s
+    $prev = $null
+    $words = @($commandAst.CommandElements | Where-Object { $_.Extent.EndOffset -lt $cursorPosition } | ForEach-Object { $_.ToString() })
+    foreach ($word in ($words | Select-Object -Skip 1)) {
+        if ($commands[$path].SubCommands.Contains($word)) {
+            $path = $commands[$path].SubCommands[$word]
+        }
+        $prev = $word
+    }
+    $node = $commands[$path]
+
+    # complete the value of the previous arg
+    $arg = $node.Args | Where-Object { $_.Names -contains $prev } | Select-Object -First 1
+    if ($arg -and ($arg.Type -ne 'NONE')) {
+        switch ($arg.Type) {
+            'OPTION' {
+                $arg.Opts | Where-Object { $_ -like "$wordToComplete*" } | ForEach-Object {
+                    [System.Management.Automation.CompletionResult]::new($_, $_, 'ParameterValue', $_)
+                }
+            }
+            'FILE' {
+                Get-ChildItem -Path "$wordToComplete*" -ErrorAction SilentlyContinue | Where-Object {
+                    $item = $_
+                    $item.PSIsContainer -or ($arg.Filter.Count -eq 0) -or ($arg.Filter | Where-Object { $item.Name -like $_ })
+                } | ForEach-Object {
+                    $relative = Resolve-Path -Relative $_.FullName
+                    [System.Management.Automation.CompletionResult]::new($relative, $_.Name, 'ProviderItem', $relative)
+                }
+            }
+            'DIRECTORY' {
+                Get-ChildItem -Directory -Path "$wordToComplete*" -ErrorAction SilentlyContinue | ForEach-Object {
+                    $relative = Resolve-Path -Relative $_.FullName
+                    [System.Management.Automation.CompletionResult]::new($relative, $_.Name, 'ProviderContainer', $relative)
+                }
+            }
+        }
+        return
+    }
+
+    # complete the sub-commands and args
+    foreach ($name in $node.SubCommands.Keys) {
+        if ($name -like "$wordToComplete*") {
+            [System.Management.Automation.CompletionResult]::new($name, $name, 'Command', $name)
+        }
+    }
+    foreach ($candidate in $node.Args) {
+        foreach ($name in $candidate.Names) {
+            if ($name -like "$wordToComplete*") {
+                [System.Management.Automation.CompletionResult]::new($name, $name, 'ParameterName', $candidate.Description)
+            }
+        }
+    }
+}
+`
+
+func writePowerShellScript(w io.Writer, cmd *BceCommand) {
+	nodes := collectScriptNodes(cmd, nil)
+
+	var cmdNames []string
+	for _, name := range cmd.commandNames() {
+		cmdNames = append(cmdNames, psQuote(name))
+	}
+
+	fmt.Fprintf(w, "# PowerShell completion for %s (generated by bce)\n\n", cmd.Name)
+	fmt.Fprintf(w, "Register-ArgumentCompleter -Native -CommandName %s -ScriptBlock {\n", strings.Join(cmdNames, ", "))
+	fmt.Fprintln(w, "    param($wordToComplete, $commandAst, $cursorPosition)")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "    $commands = @{")
+	for _, node := range nodes {
+		path := strings.Join(node.Path, "/")
+		fmt.Fprintf(w, "        %s = @{\n", psQuote(path))
+		fmt.Fprintln(w, "            SubCommands = [ordered]@{")
+		for _, subCmd := range node.Cmd.SubCommands {
+			for _, name := range subCmd.commandNames() {
+				fmt.Fprintf(w, "                %s = %s\n", psQuote(name), psQuote(path+"/"+subCmd.Name))
+			}
+		}
+		fmt.Fprintln(w, "            }")
+		fmt.Fprintln(w, "            Args = @(")
+		for _, arg := range node.Args {
+			description := singleLine(arg.Description)
+			if len(description) == 0 {
+				description = arg.argNames()[0]
+			}
+			fmt.Fprintf(w, "                @{ Names = %s; Type = %s; Description = %s; Opts = %s; Filter = %s }\n",
+				psQuoteList(arg.argNames()), psQuote(arg.ArgType), psQuote(description), psQuoteList(arg.optNames()), psQuoteList(arg.fileFilterPatterns()))
+		}
+		fmt.Fprintln(w, "            )")
+		fmt.Fprintln(w, "        }")
+	}
+	fmt.Fprintln(w, "    }")
+	fmt.Fprintf(w, powerShellCompleter, psQuote(cmd.Name))
+}
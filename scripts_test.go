@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// go test -run TestScriptGolden -update rewrites the golden files from the current generators
+var updateGolden = flag.Bool("update", false, "rewrite the golden files")
+
+func readTestSpec(t testing.TB, filename string) *BceCommand {
+	t.Helper()
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd, err := parseJsonCommand(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd
+}
+
+var scriptWriters = map[string]func(io.Writer, *BceCommand){
+	"bash":       writeBashScript,
+	"zsh":        writeZshScript,
+	"fish":       writeFishScript,
+	"powershell": writePowerShellScript,
+}
+
+// scriptSyntaxChecks are the commands parsing a script without running it
+var scriptSyntaxChecks = map[string]func(script string) []string{
+	"bash": func(script string) []string { return []string{"bash", "-n", script} },
+	"zsh":  func(script string) []string { return []string{"zsh", "-n", script} },
+	"fish": func(script string) []string { return []string{"fish", "--no-execute", script} },
+	"powershell": func(script string) []string {
+		return []string{"pwsh", "-NoProfile", "-NonInteractive", "-Command",
+			"$errors = $null; [void][System.Management.Automation.Language.Parser]::ParseFile('" + strings.ReplaceAll(script, "'", "''") + "', [ref]$null, [ref]$errors); " +
+				"if ($errors) { $errors | ForEach-Object { $_.ToString() }; exit 1 }"}
+	},
+}
+
+func TestScriptGolden(t *testing.T) {
+	for _, format := range scriptFormats {
+		t.Run(format, func(t *testing.T) {
+			cmd := readTestSpec(t, filepath.Join("testdata", "scripts", "tool.json"))
+			var buf bytes.Buffer
+			scriptWriters[format](&buf, cmd)
+
+			golden := filepath.Join("testdata", "scripts", "tool."+format+".golden")
+			if *updateGolden {
+				err := ioutil.WriteFile(golden, buf.Bytes(), 0644)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			expected, err := ioutil.ReadFile(golden)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(buf.Bytes(), expected) {
+				t.Errorf("the %s script differs from %s (go test -update to accept it):\n%s", format, golden, firstDifference(string(expected), buf.String()))
+			}
+		})
+	}
+}
+
+// the scripts parse, in the shells which are installed
+func TestScriptSyntax(t *testing.T) {
+	for _, format := range scriptFormats {
+		t.Run(format, func(t *testing.T) {
+			script := filepath.Join(t.TempDir(), "tool."+format)
+			check := scriptSyntaxChecks[format](script)
+			if _, err := exec.LookPath(check[0]); err != nil {
+				t.Skip(check[0] + " isn't installed")
+			}
+
+			var buf bytes.Buffer
+			scriptWriters[format](&buf, readTestSpec(t, filepath.Join("testdata", "scripts", "tool.json")))
+			err := ioutil.WriteFile(script, buf.Bytes(), 0644)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			output, err := exec.Command(check[0], check[1:]...).CombinedOutput()
+			if err != nil {
+				t.Errorf("%s doesn't parse the %s script: %v\n%s", check[0], format, err, output)
+			}
+		})
+	}
+}
+
+// firstDifference shows the first line which differs
+func firstDifference(expected string, actual string) string {
+	expectedLines := strings.Split(expected, "\n")
+	actualLines := strings.Split(actual, "\n")
+	for i := 0; (i < len(expectedLines)) || (i < len(actualLines)); i++ {
+		var expectedLine, actualLine string
+		if i < len(expectedLines) {
+			expectedLine = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			actualLine = actualLines[i]
+		}
+		if expectedLine != actualLine {
+			return "line " + strconv.Itoa(i+1) + ":\n- " + expectedLine + "\n+ " + actualLine
+		}
+	}
+	return ""
+}
+
+func TestCheckScriptable(t *testing.T) {
+	cmd := readTestSpec(t, filepath.Join("testdata", "scripts", "tool.json"))
+	if err := checkScriptable(cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	cmd.SubCommands[0].Args = append(cmd.SubCommands[0].Args, BceCommandArg{LongName: "--namespace", ArgType: ArgTypeGenerator, Generator: "echo default"})
+	cmd.SubCommands[1].Positionals = append(cmd.SubCommands[1].Positionals, BceCommandPositional{Position: 1, Name: "SRC", ArgType: ArgTypeFile})
+	err := checkScriptable(cmd)
+	if err == nil {
+		t.Fatal("a command with a positional and a GENERATOR arg was exported")
+	}
+	for _, expected := range []string{"tool get --namespace: is a GENERATOR arg", "tool copy-to: has the positional SRC"} {
+		if !strings.Contains(err.Error(), expected) {
+			t.Errorf("%q doesn't report %q", err, expected)
+		}
+	}
+}
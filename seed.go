@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strings"
+)
+
+// embeddedSpecs is the default spec bundle, which seeds a new database.
+// A spec is added to the bundle by putting its JSON (as exported with --format json) in the specs directory, before building.
+//
+//go:embed specs/*.json
+var embeddedSpecs embed.FS
+
+const embeddedSpecsDir = "specs"
+
+// BceEmbeddedSpec is a spec of the embedded bundle
+type BceEmbeddedSpec struct {
+	File     string
+	Checksum string
+	Command  *BceCommand
+}
+
+// EmbeddedSpecs decodes the embedded bundle, ordered by command name
+func EmbeddedSpecs() ([]BceEmbeddedSpec, error) {
+	entries, err := embeddedSpecs.ReadDir(embeddedSpecsDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var specs []BceEmbeddedSpec
+	for _, entry := range entries {
+		file := path.Join(embeddedSpecsDir, entry.Name())
+		data, err := embeddedSpecs.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		cmd, err := parseJsonCommand(data)
+		if err != nil {
+			return nil, errors.New("embedded spec " + file + ": " + err.Error())
+		}
+		specs = append(specs, BceEmbeddedSpec{File: file, Checksum: checksum(data), Command: cmd})
+	}
+	sort.Slice(specs, func(a, b int) bool { return specs[a].Command.Name < specs[b].Command.Name })
+	return specs, nil
+}
+
+// DBSeed replaces the named commands (or all, when no names are given) with those of the embedded bundle, in one transaction
+func DBSeed(conn *sql.DB, names []string) ([]string, error) {
+	specs, err := EmbeddedSpecs()
+	if err != nil {
+		return nil, err
+	}
+
+	var selected []BceEmbeddedSpec
+	for _, spec := range specs {
+		if (len(names) == 0) || contains(names, spec.Command.Name) {
+			selected = append(selected, spec)
+		}
+	}
+	for _, name := range names {
+		if !embeddedSpecsContain(specs, name) {
+			return nil, errors.New("no embedded spec for " + name + " (see --seed list)")
+		}
+	}
+
+	dbConn, tx, err := DBBegin(conn)
+	if err != nil {
+		return nil, err
+	}
+	defer dbConn.Close()
+
+	var seeded []string
+	for _, spec := range selected {
+		err = dbSeedCommand(tx, spec)
+		if err != nil {
+			_ = tx.Rollback()
+			return nil, errors.New(spec.Command.Name + ": " + err.Error())
+		}
+		seeded = append(seeded, spec.Command.Name)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	return seeded, nil
+}
+
+// dbSeedCommand replaces a command with its embedded spec, recording the revision
+func dbSeedCommand(conn BceDBConn, spec BceEmbeddedSpec) error {
+	existing, err := DBQueryCommand(conn, spec.Command.Name)
+	if err != nil {
+		return err
+	}
+	if (existing != nil) && (existing.Name != spec.Command.Name) {
+		existing = nil
+	}
+	err = DBInsertRevision(conn, spec.Command.Name, RevisionSeed, "embedded:"+spec.File, spec.Checksum, existing)
+	if err != nil {
+		return err
+	}
+	return spec.Command.ReplaceDB(conn)
+}
+
+func embeddedSpecsContain(specs []BceEmbeddedSpec, name string) bool {
+	for _, spec := range specs {
+		if spec.Command.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// dbSeedNewLayer seeds a layer's newly created database, unless another layer already has a database
+// (whose commands the seeded ones would shadow, or duplicate)
+func dbSeedNewLayer(layer string, conn *sql.DB) error {
+	for _, other := range DBLayers {
+		if other == layer {
+			continue
+		}
+		path, err := DBLayerPath(other)
+		if err != nil {
+			return err
+		}
+		if fileExists(path) {
+			return nil
+		}
+	}
+
+	seeded, err := DBSeed(conn, nil)
+	if err != nil {
+		return err
+	}
+	log.Println("Seeded the", layer, "database with", strings.Join(seeded, ", "))
+	return nil
+}
+
+// processSeed handles --seed: list the embedded specs (and whether the layer has them), or re-seed them into the layer
+// (all, or the given command names), replacing the layer's commands of the same name
+func processSeed(action string, args []string, layer string) error {
+	conn, err := DBOpenLayer(layer)
+	if err != nil {
+		return err
+	}
+	defer DBClose(conn)
+
+	if action == "list" {
+		specs, err := EmbeddedSpecs()
+		if err != nil {
+			return err
+		}
+		names, err := DBQueryRootCommandNames(conn)
+		if err != nil {
+			return err
+		}
+		for _, spec := range specs {
+			state := "not in the " + layer + " database"
+			if contains(names, spec.Command.Name) {
+				state = "in the " + layer + " database"
+			}
+			fmt.Printf("%s\t%s\t%s\n", spec.Command.Name, spec.File, state)
+		}
+		return nil
+	}
+
+	var names []string
+	if action != ExportAllCommands {
+		names = append([]string{action}, args...)
+	}
+	seeded, err := DBSeed(conn, names)
+	if err != nil {
+		return err
+	}
+	fmt.Println("Seeded", strings.Join(seeded, ", "))
+	return DBIndexRefresh(layer)
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// processInit prints the commands which register bce as the completer for every root command (and alias)
+// in the database, e.g. `eval "$(bce --init bash)"`. Registering again replaces the previous registration.
+func processInit(shell string, w io.Writer) error {
+	bcePath, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	layers, err := DBOpenLayers()
+	if err != nil {
+		return err
+	}
+	defer DBCloseLayers(layers)
+
+	cmdNames, owners, err := DBQueryRootCommandsLayered(layers)
+	if err != nil {
+		return err
+	}
+
+	// the aliases of each visible command come from the layer which defines it
+	var aliasNames []string
+	var layerAliases = make(map[string]map[string][]string)
+	for _, layer := range layers {
+		layerAliases[layer.Name], err = DBQueryRootCommandAliases(layer.Conn)
+		if err != nil {
+			return err
+		}
+	}
+	for _, cmdName := range cmdNames {
+		aliasNames = append(aliasNames, layerAliases[owners[cmdName].Name][cmdName]...)
+	}
+
+	// skip any blank names, which can't be registered
+	var names []string
+	for _, name := range append(cmdNames, aliasNames...) {
+		if len(strings.TrimSpace(name)) > 0 {
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	switch shell {
+	case "bash":
+		writeBashInit(w, bcePath, names)
+	case "zsh":
+		writeZshInit(w, bcePath, names)
+	case "fish":
+		writeFishInit(w, bcePath, names)
+	default:
+		return errors.New("unsupported shell: " + shell)
+	}
+	return nil
+}
+
+func writeBashInit(w io.Writer, bcePath string, names []string) {
+	// filenames: let bash quote special characters and skip the trailing space after a directory
+	fmt.Fprint(w, "complete -o filenames -C ", shellQuote(bcePath))
+	for _, name := range names {
+		fmt.Fprint(w, " ", shellQuote(name))
+	}
+	fmt.Fprintln(w)
+}
+
+// zshInitFunction is the completion widget, which passes the words up to the cursor to bce
+// and hands the "candidate:description" lines to _describe
+const zshInitFunction = `_bce_complete() {
+  local line="${(j: :)words[1,CURRENT-1]} ${PREFIX}"
+  local -a candidates
+  candidates=(${(f)"$(%s --zsh --line "$line" --point "${#line}" 2>/dev/null)"})
+  _describe -t bce-candidates 'completions' candidates
+}
+`
+
+func writeZshInit(w io.Writer, bcePath string, names []string) {
+	fmt.Fprintf(w, zshInitFunction, shellQuote(bcePath))
+	fmt.Fprint(w, "compdef _bce_complete")
+	for _, name := range names {
+		fmt.Fprint(w, " ", shellQuote(name))
+	}
+	fmt.Fprintln(w)
+}
+
+// processZshCompletion prints the candidates for the zsh widget, in the "candidate:description" format of _describe
+func processZshCompletion(cmdLine string, cursorPos int, w io.Writer) error {
+	input := NewCompletionInput(cmdLine, cursorPos)
+	recommendationList, err := collectCompletions(input)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range recommendationList {
+		// colons separate the candidate from its description, so must be escaped
+		candidate := strings.ReplaceAll(item.Name, ":", `\:`)
+		description := singleLine(item.Description)
+		if len(description) > 0 {
+			fmt.Fprintln(w, candidate+":"+description)
+		} else {
+			fmt.Fprintln(w, candidate)
+		}
+	}
+	return nil
+}
+
+func writeFishInit(w io.Writer, bcePath string, names []string) {
+	// the command substitution is evaluated by fish each time completion is requested
+	candidates := "(" + fishQuote(bcePath) + " --fish --line (commandline -cp))"
+	for _, name := range names {
+		// erase any previous registration, so this can be re-run
+		fmt.Fprintln(w, "complete -c", fishQuote(name), "-e")
+		fmt.Fprintln(w, "complete -c", fishQuote(name), "-f -a", fishQuote(candidates))
+	}
+}
+
+// processFishCompletion prints the candidates for `complete -c`, in the "candidate<TAB>description" format of fish
+func processFishCompletion(cmdLine string, w io.Writer) error {
+	// fish passes the command line up to the cursor
+	input := NewCompletionInput(cmdLine, len(cmdLine))
+	recommendationList, err := collectCompletions(input)
+	if err != nil {
+		return err
+	}
+
+	for _, item := range recommendationList {
+		description := singleLine(item.Description)
+		if len(description) > 0 {
+			fmt.Fprintln(w, item.Name+"\t"+description)
+		} else {
+			fmt.Fprintln(w, item.Name)
+		}
+	}
+	return nil
+}
+
+// singleLine collapses whitespace (including tabs and newlines), which would break the candidate formats
+func singleLine(value string) string {
+	return strings.Join(strings.Fields(value), " ")
+}
+
+// shellQuote wraps a value in single quotes, so it is passed literally to a POSIX-like shell
+func shellQuote(value string) string {
+	return "'" + strings.ReplaceAll(value, "'", `'\''`) + "'"
+}
+
+// fishQuote wraps a value in single quotes, escaping the characters fish treats specially within them
+func fishQuote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return "'" + strings.ReplaceAll(value, "'", `\'`) + "'"
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"io/ioutil"
+	"math"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// the attributes of each object of a spec, anything else is rejected (typically a misspelling)
+var (
+	specWrapperAttributes    = []string{"command"}
+	specCommandAttributes    = []string{"uuid", "name", "aliases", "sub_commands", "args", "positionals"}
+	specAliasAttributes      = []string{"uuid", "name"}
+	specArgAttributes        = []string{"uuid", "arg_type", "description", "long_name", "short_name", "file_filter", "generator", "generator_ttl", "opts"}
+	specPositionalAttributes = []string{"uuid", "position", "name", "arity", "arg_type", "description", "file_filter", "generator", "generator_ttl", "opts"}
+	specOptAttributes        = []string{"uuid", "name"}
+)
+
+// ArgTypes are the types of an arg, positionals take a value so can't be NONE
+var ArgTypes = []string{ArgTypeNone, ArgTypeOption, ArgTypeFile, ArgTypeDirectory, ArgTypeText, ArgTypeGenerator}
+
+var PositionalArgTypes = []string{ArgTypeOption, ArgTypeFile, ArgTypeDirectory, ArgTypeText, ArgTypeGenerator}
+
+var Arities = []string{ArityExactly, ArityOptional, ArityVariadic}
+
+// BceSpecProblems lists every problem found in a spec, each located by its JSON path
+type BceSpecProblems []string
+
+func (problems BceSpecProblems) Error() string {
+	return strings.Join(problems, "\n")
+}
+
+// bceSpecDecoder converts a decoded spec (maps, lists and scalars) into data model objects, checking it strictly.
+// Rather than stopping at the first problem, it carries on to report them all.
+type bceSpecDecoder struct {
+	problems BceSpecProblems
+	// uuids maps the uuids given in the spec (or in the specs of its bundle) to where they were first used
+	uuids map[string]string
+	// bundlePath locates the spec within its bundle, for the uuids
+	bundlePath string
+}
+
+// parseJsonCommand converts a JSON spec ({"command": {...}}) into data model objects
+func parseJsonCommand(data []byte) (*BceCommand, error) {
+	var spec interface{}
+	err := json.Unmarshal(data, &spec)
+	if err != nil {
+		return nil, jsonErrorLocation(data, err)
+	}
+	return decodeSpec(spec)
+}
+
+// decodeSpec converts a decoded spec, returning BceSpecProblems if it is invalid
+func decodeSpec(spec interface{}) (*BceCommand, error) {
+	d := &bceSpecDecoder{uuids: map[string]string{}}
+	return d.decode(spec)
+}
+
+func (d *bceSpecDecoder) decode(spec interface{}) (*BceCommand, error) {
+	wrapper, ok := d.object("", spec, specWrapperAttributes)
+	if !ok {
+		return nil, d.problems
+	}
+	if wrapper["command"] == nil {
+		d.problem("command", "is required")
+		return nil, d.problems
+	}
+	cmd := d.command("command", nil, wrapper["command"])
+	if len(d.problems) > 0 {
+		return nil, d.problems
+	}
+	return &cmd, nil
+}
+
+// jsonErrorLocation adds the line and column of a syntax error
+func jsonErrorLocation(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &syntaxErr) {
+		offset = syntaxErr.Offset
+	} else if errors.As(err, &typeErr) {
+		offset = typeErr.Offset
+	} else {
+		return err
+	}
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	line := bytes.Count(data[:offset], []byte("\n")) + 1
+	column := offset - int64(bytes.LastIndexByte(data[:offset], '\n'))
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+func (d *bceSpecDecoder) problem(path string, message string) {
+	if len(path) == 0 {
+		path = "(top level)"
+	}
+	d.problems = append(d.problems, path+": "+message)
+}
+
+func specPath(path string, key string) string {
+	if len(path) == 0 {
+		return key
+	}
+	return path + "." + key
+}
+
+func specIndex(path string, i int) string {
+	return fmt.Sprintf("%s[%d]", path, i)
+}
+
+// object checks the value is an object, with only the given attributes
+func (d *bceSpecDecoder) object(path string, value interface{}, attributes []string) (map[string]interface{}, bool) {
+	data, ok := value.(map[string]interface{})
+	if !ok {
+		d.problem(path, "must be an object")
+		return nil, false
+	}
+	var unknown []string
+	for key := range data {
+		if !contains(attributes, key) {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		d.problem(specPath(path, key), "is not a known attribute (expected one of "+strings.Join(attributes, ", ")+")")
+	}
+	return data, true
+}
+
+// list reads an optional list of objects
+func (d *bceSpecDecoder) list(path string, data map[string]interface{}, key string) []interface{} {
+	value := data[key]
+	if value == nil {
+		return nil
+	}
+	items, ok := value.([]interface{})
+	if !ok {
+		d.problem(specPath(path, key), "must be a list")
+		return nil
+	}
+	return items
+}
+
+// str reads a string, which can't be empty if it is required
+func (d *bceSpecDecoder) str(path string, data map[string]interface{}, key string, required bool) string {
+	value := data[key]
+	if value == nil {
+		if required {
+			d.problem(specPath(path, key), "is required")
+		}
+		return ""
+	}
+	s, ok := value.(string)
+	if !ok {
+		d.problem(specPath(path, key), "must be a string")
+		return ""
+	}
+	if required && (len(strings.TrimSpace(s)) == 0) {
+		d.problem(specPath(path, key), "must not be empty")
+	}
+	return s
+}
+
+// name reads a required name, which is typed as a single word
+func (d *bceSpecDecoder) name(path string, data map[string]interface{}) string {
+	name := d.str(path, data, "name", true)
+	if strings.IndexFunc(name, unicode.IsSpace) >= 0 {
+		d.problem(specPath(path, "name"), "must not contain whitespace")
+	}
+	return name
+}
+
+// integer reads a whole number, zero when it is missing
+func (d *bceSpecDecoder) integer(path string, data map[string]interface{}, key string, required bool) int {
+	value := data[key]
+	if value == nil {
+		if required {
+			d.problem(specPath(path, key), "is required")
+		}
+		return 0
+	}
+	var number float64
+	switch n := value.(type) {
+	case float64:
+		number = n
+	case int:
+		number = float64(n)
+	case int64:
+		number = float64(n)
+	default:
+		d.problem(specPath(path, key), "must be a number")
+		return 0
+	}
+	if (number != math.Trunc(number)) || (math.Abs(number) > math.MaxInt32) {
+		d.problem(specPath(path, key), "must be a whole number")
+		return 0
+	}
+	return int(number)
+}
+
+// oneOf checks a value against the allowed ones
+func (d *bceSpecDecoder) oneOf(path string, value string, allowed []string) {
+	if (len(value) > 0) && !contains(allowed, value) {
+		d.problem(path, "unknown value "+value+" (expected one of "+strings.Join(allowed, ", ")+")")
+	}
+}
+
+// uuid reads an optional uuid, generating one when it is missing. Uuids are unique across the spec (and its bundle).
+func (d *bceSpecDecoder) uuid(path string, data map[string]interface{}) string {
+	id := d.str(path, data, "uuid", false)
+	if len(id) == 0 {
+		return uuid.New().String()
+	}
+	if first, ok := d.uuids[id]; ok {
+		d.problem(specPath(path, "uuid"), "duplicates the uuid of "+first)
+	}
+	d.uuids[id] = d.bundlePath + path
+	return id
+}
+
+// unique checks a name isn't used twice within a scope (mapping the names to where they were first used)
+func (d *bceSpecDecoder) unique(scope map[string]string, path string, name string, what string) {
+	if len(name) == 0 {
+		return
+	}
+	if first, ok := scope[name]; ok {
+		d.problem(path, "duplicates the "+what+" "+name+" of "+first)
+		return
+	}
+	scope[name] = path
+}
+
+func (d *bceSpecDecoder) command(path string, parentUuid *string, value interface{}) BceCommand {
+	var cmd BceCommand
+	data, ok := d.object(path, value, specCommandAttributes)
+	if !ok {
+		return cmd
+	}
+	cmd.Uuid = d.uuid(path, data)
+	cmd.Name = d.name(path, data)
+	cmd.ParentCmdUuid = parentUuid
+
+	// a command's name and aliases can't be confused
+	names := map[string]string{cmd.Name: specPath(path, "name")}
+	for i, value := range d.list(path, data, "aliases") {
+		aliasPath := specIndex(specPath(path, "aliases"), i)
+		alias, ok := d.object(aliasPath, value, specAliasAttributes)
+		if !ok {
+			continue
+		}
+		name := d.name(aliasPath, alias)
+		d.unique(names, specPath(aliasPath, "name"), name, "name")
+		cmd.Aliases = append(cmd.Aliases, BceCommandAlias{Uuid: d.uuid(aliasPath, alias), CmdUuid: cmd.Uuid, Name: name})
+	}
+
+	longNames := map[string]string{}
+	shortNames := map[string]string{}
+	for i, value := range d.list(path, data, "args") {
+		argPath := specIndex(specPath(path, "args"), i)
+		arg, ok := d.arg(argPath, cmd.Uuid, value)
+		if !ok {
+			continue
+		}
+		d.unique(longNames, specPath(argPath, "long_name"), arg.LongName, "long_name")
+		d.unique(shortNames, specPath(argPath, "short_name"), arg.ShortName, "short_name")
+		cmd.Args = append(cmd.Args, arg)
+	}
+
+	positions := map[string]string{}
+	for i, value := range d.list(path, data, "positionals") {
+		positionalPath := specIndex(specPath(path, "positionals"), i)
+		positional, ok := d.positional(positionalPath, cmd.Uuid, value)
+		if !ok {
+			continue
+		}
+		d.unique(positions, specPath(positionalPath, "position"), fmt.Sprint(positional.Position), "position")
+		cmd.Positionals = append(cmd.Positionals, positional)
+	}
+	for _, positional := range cmd.Positionals {
+		if positional.Arity != ArityVariadic {
+			continue
+		}
+		for _, other := range cmd.Positionals {
+			if other.Position > positional.Position {
+				d.problem(positions[fmt.Sprint(positional.Position)], "a VARIADIC positional must be the last")
+				break
+			}
+		}
+	}
+
+	// sub-commands are typed in the same place, so their names and aliases can't be confused
+	subNames := map[string]string{}
+	for i, value := range d.list(path, data, "sub_commands") {
+		subPath := specIndex(specPath(path, "sub_commands"), i)
+		subCmd := d.command(subPath, &cmd.Uuid, value)
+		d.unique(subNames, specPath(subPath, "name"), subCmd.Name, "sub-command")
+		for j, alias := range subCmd.Aliases {
+			d.unique(subNames, specPath(specIndex(specPath(subPath, "aliases"), j), "name"), alias.Name, "sub-command")
+		}
+		cmd.SubCommands = append(cmd.SubCommands, subCmd)
+	}
+	return cmd
+}
+
+func (d *bceSpecDecoder) arg(path string, cmdUuid string, value interface{}) (BceCommandArg, bool) {
+	var arg BceCommandArg
+	data, ok := d.object(path, value, specArgAttributes)
+	if !ok {
+		return arg, false
+	}
+	arg.Uuid = d.uuid(path, data)
+	arg.CmdUuid = cmdUuid
+	arg.ArgType = d.str(path, data, "arg_type", true)
+	d.oneOf(specPath(path, "arg_type"), arg.ArgType, ArgTypes)
+	arg.Description = d.str(path, data, "description", true)
+	arg.LongName = d.str(path, data, "long_name", false)
+	arg.ShortName = d.str(path, data, "short_name", false)
+	if (len(arg.LongName) == 0) && (len(arg.ShortName) == 0) {
+		d.problem(path, "long_name or short_name is required")
+	}
+	arg.FileFilter = d.str(path, data, "file_filter", false)
+	arg.Generator = d.str(path, data, "generator", arg.ArgType == ArgTypeGenerator)
+	arg.GeneratorTTL = d.integer(path, data, "generator_ttl", false)
+	arg.Opts = d.opts(path, arg.Uuid, data)
+	return arg, true
+}
+
+func (d *bceSpecDecoder) positional(path string, cmdUuid string, value interface{}) (BceCommandPositional, bool) {
+	var positional BceCommandPositional
+	data, ok := d.object(path, value, specPositionalAttributes)
+	if !ok {
+		return positional, false
+	}
+	positional.Uuid = d.uuid(path, data)
+	positional.CmdUuid = cmdUuid
+	positional.Position = d.integer(path, data, "position", true)
+	if (data["position"] != nil) && (positional.Position < 1) {
+		d.problem(specPath(path, "position"), "must be 1 or more")
+	}
+	positional.Name = d.str(path, data, "name", true)
+	positional.Arity = d.str(path, data, "arity", false)
+	if len(positional.Arity) == 0 {
+		positional.Arity = ArityExactly
+	}
+	d.oneOf(specPath(path, "arity"), positional.Arity, Arities)
+	positional.ArgType = d.str(path, data, "arg_type", true)
+	d.oneOf(specPath(path, "arg_type"), positional.ArgType, PositionalArgTypes)
+	positional.Description = d.str(path, data, "description", true)
+	positional.FileFilter = d.str(path, data, "file_filter", false)
+	positional.Generator = d.str(path, data, "generator", positional.ArgType == ArgTypeGenerator)
+	positional.GeneratorTTL = d.integer(path, data, "generator_ttl", false)
+	positional.Opts = d.opts(path, positional.Uuid, data)
+	return positional, true
+}
+
+// opts reads the opts of an arg or positional
+func (d *bceSpecDecoder) opts(path string, argUuid string, data map[string]interface{}) []BceCommandOpt {
+	var opts []BceCommandOpt
+	names := map[string]string{}
+	for i, value := range d.list(path, data, "opts") {
+		optPath := specIndex(specPath(path, "opts"), i)
+		opt, ok := d.object(optPath, value, specOptAttributes)
+		if !ok {
+			continue
+		}
+		name := d.str(optPath, opt, "name", true)
+		d.unique(names, specPath(optPath, "name"), name, "opt")
+		opts = append(opts, BceCommandOpt{Uuid: d.uuid(optPath, opt), ArgUuid: argUuid, Name: name})
+	}
+	return opts
+}
+
+// processValidate checks spec files (or bundles) without importing them, reporting every problem.
+// Files are read in the given spec format, or that of their extension.
+func processValidate(filenames []string, format string) error {
+	if len(filenames) == 0 {
+		return errors.New("validate requires a file")
+	}
+
+	var invalid int
+	for _, filename := range filenames {
+		data, err := ioutil.ReadFile(filename)
+		if err == nil {
+			var cmds []*BceCommand
+			cmds, err = parseSpecBundle(data, specFileFormat(filename, format))
+			if err == nil {
+				fmt.Printf("%s: valid (%d commands)\n", filename, len(cmds))
+				continue
+			}
+		}
+		invalid++
+		for _, problem := range strings.Split(err.Error(), "\n") {
+			fmt.Printf("%s: %s\n", filename, problem)
+		}
+	}
+
+	if invalid > 0 {
+		return fmt.Errorf("%d of %d files are invalid", invalid, len(filenames))
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package main
+
+import "database/sql"
+
+// sqlCommandTree selects the uuids of a command (?1) and all of its descendents, for the tree queries.
+// The tree drives the joins (CROSS JOIN keeps the join order), so each row is found through the parent's index,
+// rather than by scanning the whole table.
+const sqlCommandTree = `
+	WITH RECURSIVE tree(uuid) AS (
+		SELECT ?1
+		UNION
+		SELECT c.uuid
+		FROM tree t
+		CROSS JOIN command c ON c.parent_cmd = t.uuid
+	)
+`
+
+const sqlReadTreeCommands = sqlCommandTree + `
+	SELECT c.uuid, c.name, c.parent_cmd
+	FROM tree t
+	CROSS JOIN command c ON c.uuid = t.uuid
+	ORDER BY c.parent_cmd, c.name
+`
+
+const sqlReadTreeAliases = sqlCommandTree + `
+	SELECT a.uuid, a.cmd_uuid, a.name
+	FROM tree t
+	CROSS JOIN command_alias a ON a.cmd_uuid = t.uuid
+`
+
+const sqlReadTreeArgs = sqlCommandTree + `
+	SELECT ca.uuid, ca.cmd_uuid, ca.arg_type, ca.description, COALESCE(ca.long_name, ''), COALESCE(ca.short_name, ''), COALESCE(ca.file_filter, ''), COALESCE(ca.generator, ''), COALESCE(ca.generator_ttl, 0)
+	FROM tree t
+	CROSS JOIN command_arg ca ON ca.cmd_uuid = t.uuid
+	ORDER BY ca.cmd_uuid, ca.long_name, ca.short_name
+`
+
+const sqlReadTreeOpts = sqlCommandTree + `
+	SELECT co.uuid, co.cmd_arg_uuid, co.name
+	FROM tree t
+	CROSS JOIN command_arg ca ON ca.cmd_uuid = t.uuid
+	CROSS JOIN command_opt co ON co.cmd_arg_uuid = ca.uuid
+	ORDER BY co.cmd_arg_uuid, co.name
+`
+
+const sqlReadTreePositionals = sqlCommandTree + `
+	SELECT cp.uuid, cp.cmd_uuid, cp.position, cp.name, cp.arity, cp.arg_type, cp.description, COALESCE(cp.file_filter, ''), COALESCE(cp.generator, ''), COALESCE(cp.generator_ttl, 0)
+	FROM tree t
+	CROSS JOIN command_positional cp ON cp.cmd_uuid = t.uuid
+	ORDER BY cp.cmd_uuid, cp.position
+`
+
+const sqlReadTreePositionalOpts = sqlCommandTree + `
+	SELECT po.uuid, po.positional_uuid, po.name
+	FROM tree t
+	CROSS JOIN command_positional cp ON cp.cmd_uuid = t.uuid
+	CROSS JOIN command_positional_opt po ON po.positional_uuid = cp.uuid
+	ORDER BY po.positional_uuid, po.name
+`
+
+const sqlReadSubCommand = `
+	SELECT DISTINCT c.uuid, c.name, c.parent_cmd
+	FROM command c
+	LEFT JOIN command_alias a ON a.cmd_uuid = c.uuid
+	WHERE c.parent_cmd = ?1
+	AND (c.name = ?2 OR a.name = ?2)
+`
+
+const sqlReadSubCommands = `
+	SELECT c.uuid, c.name, c.parent_cmd
+	FROM command c
+	WHERE c.parent_cmd = ?1
+	ORDER BY c.name
+`
+
+const sqlReadSubCommandAliases = `
+	SELECT a.uuid, a.cmd_uuid, a.name
+	FROM command c
+	JOIN command_alias a ON a.cmd_uuid = c.uuid
+	WHERE c.parent_cmd = ?1
+`
+
+// bceCommandTree holds the rows of a command tree, by the uuid of their parent, while the tree is assembled
+type bceCommandTree struct {
+	commands       map[string]*BceCommand
+	subCommands    map[string][]string
+	aliases        map[string][]BceCommandAlias
+	args           map[string][]BceCommandArg
+	opts           map[string][]BceCommandOpt
+	positionals    map[string][]BceCommandPositional
+	positionalOpts map[string][]BceCommandOpt
+}
+
+// DBQueryCommandTree loads a command and all of its descendents, with one query per table (rather than per node)
+func DBQueryCommandTree(conn BceDBConn, cmdUuid string) (*BceCommand, error) {
+	tree := bceCommandTree{
+		commands:       map[string]*BceCommand{},
+		subCommands:    map[string][]string{},
+		aliases:        map[string][]BceCommandAlias{},
+		args:           map[string][]BceCommandArg{},
+		opts:           map[string][]BceCommandOpt{},
+		positionals:    map[string][]BceCommandPositional{},
+		positionalOpts: map[string][]BceCommandOpt{},
+	}
+
+	err := tree.queryCommands(conn, cmdUuid)
+	if err != nil {
+		return nil, err
+	}
+	if tree.commands[cmdUuid] == nil {
+		return nil, nil
+	}
+	err = tree.queryAliases(conn, cmdUuid)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.queryArgs(conn, cmdUuid)
+	if err != nil {
+		return nil, err
+	}
+	err = tree.queryPositionals(conn, cmdUuid)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := tree.assemble(cmdUuid)
+	return &cmd, nil
+}
+
+func (tree *bceCommandTree) queryCommands(conn BceDBConn, cmdUuid string) error {
+	rows, err := conn.Query(sqlReadTreeCommands, cmdUuid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var cmd BceCommand
+		err = rows.Scan(&cmd.Uuid, &cmd.Name, &cmd.ParentCmdUuid)
+		if err != nil {
+			return err
+		}
+		tree.commands[cmd.Uuid] = &cmd
+		if (cmd.Uuid != cmdUuid) && (cmd.ParentCmdUuid != nil) {
+			tree.subCommands[*cmd.ParentCmdUuid] = append(tree.subCommands[*cmd.ParentCmdUuid], cmd.Uuid)
+		}
+	}
+	return rows.Err()
+}
+
+func (tree *bceCommandTree) queryAliases(conn BceDBConn, cmdUuid string) error {
+	rows, err := conn.Query(sqlReadTreeAliases, cmdUuid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var alias BceCommandAlias
+		err = rows.Scan(&alias.Uuid, &alias.CmdUuid, &alias.Name)
+		if err != nil {
+			return err
+		}
+		tree.aliases[alias.CmdUuid] = append(tree.aliases[alias.CmdUuid], alias)
+	}
+	return rows.Err()
+}
+
+func (tree *bceCommandTree) queryArgs(conn BceDBConn, cmdUuid string) error {
+	rows, err := conn.Query(sqlReadTreeArgs, cmdUuid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var arg BceCommandArg
+		err = rows.Scan(&arg.Uuid, &arg.CmdUuid, &arg.ArgType, &arg.Description, &arg.LongName, &arg.ShortName, &arg.FileFilter,
+			&arg.Generator, &arg.GeneratorTTL)
+		if err != nil {
+			return err
+		}
+		tree.args[arg.CmdUuid] = append(tree.args[arg.CmdUuid], arg)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	optRows, err := conn.Query(sqlReadTreeOpts, cmdUuid)
+	if err != nil {
+		return err
+	}
+	defer optRows.Close()
+
+	for optRows.Next() {
+		var opt BceCommandOpt
+		err = optRows.Scan(&opt.Uuid, &opt.ArgUuid, &opt.Name)
+		if err != nil {
+			return err
+		}
+		tree.opts[opt.ArgUuid] = append(tree.opts[opt.ArgUuid], opt)
+	}
+	return optRows.Err()
+}
+
+func (tree *bceCommandTree) queryPositionals(conn BceDBConn, cmdUuid string) error {
+	rows, err := conn.Query(sqlReadTreePositionals, cmdUuid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var positional BceCommandPositional
+		err = rows.Scan(&positional.Uuid, &positional.CmdUuid, &positional.Position, &positional.Name, &positional.Arity,
+			&positional.ArgType, &positional.Description, &positional.FileFilter, &positional.Generator, &positional.GeneratorTTL)
+		if err != nil {
+			return err
+		}
+		tree.positionals[positional.CmdUuid] = append(tree.positionals[positional.CmdUuid], positional)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	optRows, err := conn.Query(sqlReadTreePositionalOpts, cmdUuid)
+	if err != nil {
+		return err
+	}
+	defer optRows.Close()
+
+	for optRows.Next() {
+		var opt BceCommandOpt
+		err = optRows.Scan(&opt.Uuid, &opt.ArgUuid, &opt.Name)
+		if err != nil {
+			return err
+		}
+		tree.positionalOpts[opt.ArgUuid] = append(tree.positionalOpts[opt.ArgUuid], opt)
+	}
+	return optRows.Err()
+}
+
+// assemble builds the command from the rows, children first (as sub-commands are held by value)
+func (tree *bceCommandTree) assemble(cmdUuid string) BceCommand {
+	cmd := *tree.commands[cmdUuid]
+	cmd.Aliases = tree.aliases[cmdUuid]
+
+	for _, arg := range tree.args[cmdUuid] {
+		arg.Opts = tree.opts[arg.Uuid]
+		cmd.Args = append(cmd.Args, arg)
+	}
+	for _, positional := range tree.positionals[cmdUuid] {
+		positional.Opts = tree.positionalOpts[positional.Uuid]
+		cmd.Positionals = append(cmd.Positionals, positional)
+	}
+	for _, subCmdUuid := range tree.subCommands[cmdUuid] {
+		cmd.SubCommands = append(cmd.SubCommands, tree.assemble(subCmdUuid))
+	}
+	return cmd
+}
+
+// DBQueryCommandPath loads a root command (by name or alias) for completing the words, returning nil if it doesn't exist.
+// Only the sub-commands typed in the words are loaded (resolved level by level), with their aliases, args and positionals,
+// and the sub-commands which may be typed next (with their aliases only). Anything else would be pruned anyway.
+func DBQueryCommandPath(conn BceDBConn, cmdName string, words []string) (*BceCommand, error) {
+	var cmd BceCommand
+	err := conn.QueryRow(sqlReadCommand, cmdName, cmdName).Scan(&cmd.Uuid, &cmd.Name, &cmd.ParentCmdUuid)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = cmd.queryDetails(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	cmdLine, err := cmd.parseCommandLine(words, func(current *BceCommand, word string) (*BceCommand, error) {
+		var subCmd BceCommand
+		err := conn.QueryRow(sqlReadSubCommand, current.Uuid, word).Scan(&subCmd.Uuid, &subCmd.Name, &subCmd.ParentCmdUuid)
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		err = subCmd.queryDetails(conn)
+		if err != nil {
+			return nil, err
+		}
+		// the siblings of a typed sub-command aren't needed
+		current.SubCommands = []BceCommand{subCmd}
+		return &current.SubCommands[0], nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	last := cmdLine.Path[len(cmdLine.Path)-1]
+	err = last.querySubCommandNames(conn)
+	if err != nil {
+		return nil, err
+	}
+	return &cmd, nil
+}
+
+// queryDetails loads the aliases, args and positionals of a command (but not its sub-commands)
+func (cmd *BceCommand) queryDetails(conn BceDBConn) error {
+	err := cmd.QueryAliases(conn)
+	if err != nil {
+		return err
+	}
+	err = cmd.QueryArgs(conn)
+	if err != nil {
+		return err
+	}
+	return cmd.QueryPositionals(conn)
+}
+
+// querySubCommandNames loads the sub-commands of a command, with their aliases only
+func (cmd *BceCommand) querySubCommandNames(conn BceDBConn) error {
+	cmd.SubCommands = nil
+
+	rows, err := conn.Query(sqlReadSubCommands, cmd.Uuid)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var index = map[string]int{}
+	for rows.Next() {
+		var subCmd BceCommand
+		err = rows.Scan(&subCmd.Uuid, &subCmd.Name, &subCmd.ParentCmdUuid)
+		if err != nil {
+			return err
+		}
+		index[subCmd.Uuid] = len(cmd.SubCommands)
+		cmd.SubCommands = append(cmd.SubCommands, subCmd)
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	aliasRows, err := conn.Query(sqlReadSubCommandAliases, cmd.Uuid)
+	if err != nil {
+		return err
+	}
+	defer aliasRows.Close()
+
+	for aliasRows.Next() {
+		var alias BceCommandAlias
+		err = aliasRows.Scan(&alias.Uuid, &alias.CmdUuid, &alias.Name)
+		if err != nil {
+			return err
+		}
+		if i, ok := index[alias.CmdUuid]; ok {
+			cmd.SubCommands[i].Aliases = append(cmd.SubCommands[i].Aliases, alias)
+		}
+	}
+	return aliasRows.Err()
+}
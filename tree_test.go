@@ -0,0 +1,195 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// syntheticTreeWidth is the number of sub-commands of each command, 4 levels deep, so the tree has 11111 commands
+const syntheticTreeWidth = 10
+const syntheticTreeDepth = 4
+
+// syntheticTreeBudget is the most loading the whole synthetic tree (to export it, or for the daemon) may take,
+// and syntheticPathBudget the most loading the path completed on a key press may take, on a cold connection
+const syntheticTreeBudget = 2 * time.Second
+const syntheticPathBudget = 50 * time.Millisecond
+
+// syntheticPathWords are the words completed in the synthetic tree, by name and by alias
+var syntheticPathWords = []string{"tool", "tool-3", "tool-3-1-alias", "--output", "json", "tool-3-1-4"}
+
+// syntheticCommand builds a command with an alias and args (one with opts) and a positional, and its sub-commands
+func syntheticCommand(name string, parentUuid *string, depth int) BceCommand {
+	cmdUuid := uuid.New().String()
+	argUuid := uuid.New().String()
+	positionalUuid := uuid.New().String()
+	cmd := BceCommand{
+		Uuid:          cmdUuid,
+		Name:          name,
+		ParentCmdUuid: parentUuid,
+		Aliases:       []BceCommandAlias{{Uuid: uuid.New().String(), CmdUuid: cmdUuid, Name: name + "-alias"}},
+		Args: []BceCommandArg{
+			{Uuid: uuid.New().String(), CmdUuid: cmdUuid, ArgType: ArgTypeNone, LongName: "--verbose", ShortName: "-v"},
+			{Uuid: argUuid, CmdUuid: cmdUuid, ArgType: ArgTypeOption, LongName: "--output", ShortName: "-o", Opts: []BceCommandOpt{
+				{Uuid: uuid.New().String(), ArgUuid: argUuid, Name: "json"},
+				{Uuid: uuid.New().String(), ArgUuid: argUuid, Name: "yaml"},
+			}},
+		},
+		Positionals: []BceCommandPositional{
+			{Uuid: positionalUuid, CmdUuid: cmdUuid, Position: 1, Name: "NAME", Arity: ArityOptional, ArgType: ArgTypeOption, Opts: []BceCommandOpt{
+				{Uuid: uuid.New().String(), ArgUuid: positionalUuid, Name: "all"},
+			}},
+		},
+	}
+	if depth < syntheticTreeDepth {
+		for i := 0; i < syntheticTreeWidth; i++ {
+			cmd.SubCommands = append(cmd.SubCommands, syntheticCommand(name+"-"+strconv.Itoa(i), &cmdUuid, depth+1))
+		}
+	}
+	return cmd
+}
+
+// createSyntheticTreeDB writes the synthetic tree into a database in the test's temporary directory
+func createSyntheticTreeDB(tb testing.TB) (string, *BceCommand) {
+	tb.Helper()
+	path := filepath.Join(tb.TempDir(), "completion.db")
+	conn, err := DBOpen(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer DBClose(conn)
+	err = DBEnsureSchema(conn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	cmd := syntheticCommand("tool", nil, 0)
+	dbConn, tx, err := DBBegin(conn)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer dbConn.Close()
+	err = cmd.InsertDB(tx)
+	if err == nil {
+		err = tx.Commit()
+	}
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return path, &cmd
+}
+
+func countCommands(cmd *BceCommand) int {
+	count := 1
+	for i := range cmd.SubCommands {
+		count += countCommands(&cmd.SubCommands[i])
+	}
+	return count
+}
+
+func openSyntheticTreeDB(tb testing.TB, path string) *sql.DB {
+	tb.Helper()
+	conn, err := DBOpen(path)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return conn
+}
+
+func BenchmarkDBQueryCommandTree(b *testing.B) {
+	path, cmd := createSyntheticTreeDB(b)
+	conn := openSyntheticTreeDB(b, path)
+	defer DBClose(conn)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := DBQueryCommandTree(conn, cmd.Uuid)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// completing a word only loads the path to it
+func BenchmarkDBQueryCommandPath(b *testing.B) {
+	path, _ := createSyntheticTreeDB(b)
+	conn := openSyntheticTreeDB(b, path)
+	defer DBClose(conn)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := DBQueryCommandPath(conn, "tool", syntheticPathWords)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// bestLoadTime is the fastest of a few loads, each on a cold connection, so a busy machine doesn't fail the budget
+func bestLoadTime(t *testing.T, path string, load func(conn *sql.DB) (*BceCommand, error), check func(cmd *BceCommand)) time.Duration {
+	t.Helper()
+	var best time.Duration
+	for i := 0; i < 3; i++ {
+		conn := openSyntheticTreeDB(t, path)
+		start := time.Now()
+		cmd, err := load(conn)
+		elapsed := time.Since(start)
+		DBClose(conn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if cmd == nil {
+			t.Fatal("the synthetic tree wasn't found")
+		}
+		check(cmd)
+		if (i == 0) || (elapsed < best) {
+			best = elapsed
+		}
+	}
+	return best
+}
+
+// the synthetic tree loads whole, or along the completed path, within the budgets
+func TestDBQueryCommandTreeBudget(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a synthetic tree of 11111 commands")
+	}
+	path, cmd := createSyntheticTreeDB(t)
+	count := countCommands(cmd)
+
+	elapsed := bestLoadTime(t, path, func(conn *sql.DB) (*BceCommand, error) {
+		return DBQueryCommandTree(conn, cmd.Uuid)
+	}, func(loaded *BceCommand) {
+		if countCommands(loaded) != count {
+			t.Fatalf("loaded %d commands of %d", countCommands(loaded), count)
+		}
+	})
+	t.Logf("loaded %d commands in %v", count, elapsed)
+	if elapsed > syntheticTreeBudget {
+		t.Errorf("loading %d commands took %v, over the budget of %v", count, elapsed, syntheticTreeBudget)
+	}
+
+	elapsed = bestLoadTime(t, path, func(conn *sql.DB) (*BceCommand, error) {
+		return DBQueryCommandPath(conn, "tool", syntheticPathWords)
+	}, func(loaded *BceCommand) {
+		// the typed sub-commands, then the names which may be typed next
+		last := loaded
+		for _, name := range []string{"tool-3", "tool-3-1", "tool-3-1-4"} {
+			if (len(last.SubCommands) != 1) || (last.SubCommands[0].Name != name) {
+				t.Fatalf("%s wasn't loaded alone, loaded %d sub-commands of %s", name, len(last.SubCommands), last.Name)
+			}
+			last = &last.SubCommands[0]
+		}
+		if len(last.SubCommands) != syntheticTreeWidth {
+			t.Fatalf("loaded %d sub-commands of %s", len(last.SubCommands), last.Name)
+		}
+	})
+	t.Logf("loaded the path %v in %v", syntheticPathWords, elapsed)
+	if elapsed > syntheticPathBudget {
+		t.Errorf("loading the path %v took %v, over the budget of %v", syntheticPathWords, elapsed, syntheticPathBudget)
+	}
+}